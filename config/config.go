@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 )
 
 type Option func(*config) error
@@ -63,6 +64,533 @@ func MoeKeys(v []string) Option {
 	}
 }
 
+// JSON のマニフェストか S3 互換のバケット一覧 (ListObjects) の URL。
+// マニフェストは [{"key" or "url", "title", "tags"}] で、key は MoeURL からの相対パス
+func MoeManifestURL(v string) Option {
+	return func(c *config) error {
+		c.moeManifestURL = v
+		return nil
+	}
+}
+
+// 0 なら起動時に一度だけ読む
+func MoeRefreshInterval(v time.Duration) Option {
+	return func(c *config) error {
+		c.moeRefreshInterval = v
+		return nil
+	}
+}
+
+// 0 なら上限なし
+func ImageUploadMaxBytes(v int64) Option {
+	return func(c *config) error {
+		c.imageUploadMaxBytes = v
+		return nil
+	}
+}
+
+// ホスト名 -> Authorization ヘッダの値。アップロードのために画像を取ってくるときに使う
+func ImageFetchAuth(v map[string]string) Option {
+	return func(c *config) error {
+		c.imageFetchAuth = v
+		return nil
+	}
+}
+
+// URL を貼る代わりに bot が画像を取ってきて Slack にアップロードする
+func MoeUpload(v bool) Option {
+	return func(c *config) error {
+		c.moeUpload = v
+		return nil
+	}
+}
+
+// Scheme は "hmac" (?expires=&signature=) か "s3" (SigV4 の presign)
+type URLSigning struct {
+	Scheme string
+	// hmac なら共有鍵、s3 ならシークレットアクセスキー
+	Secret      string
+	AccessKeyID string
+	Region      string
+	Expiry      time.Duration
+}
+
+// MoeURL 配下の画像は署名付きの期限切れになる URL で貼る
+func MoeURLSigning(v URLSigning) Option {
+	return func(c *config) error {
+		switch v.Scheme {
+		case "hmac":
+		case "s3":
+			if v.AccessKeyID == "" || v.Region == "" {
+				return fmt.Errorf("URLSigning AccessKeyID and Region required for s3")
+			}
+		default:
+			return fmt.Errorf("unknown URLSigning Scheme: %s", v.Scheme)
+		}
+		if v.Secret == "" {
+			return fmt.Errorf("URLSigning Secret required")
+		}
+		if v.Expiry <= 0 {
+			v.Expiry = 24 * time.Hour
+		}
+		c.moeURLSigning = v
+		return nil
+	}
+}
+
+func DictionaryPath(v string) Option {
+	return func(c *config) error {
+		c.dictionaryPath = v
+		return nil
+	}
+}
+
+func Timezone(v string) Option {
+	return func(c *config) error {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			return err
+		}
+		c.location = loc
+		return nil
+	}
+}
+
+func DailyChannel(v string) Option {
+	return func(c *config) error {
+		c.dailyChannel = v
+		return nil
+	}
+}
+
+func DailyPostTime(v string) Option {
+	return func(c *config) error {
+		t, err := time.Parse("15:04", v)
+		if err != nil {
+			return fmt.Errorf("DailyPostTime must be HH:MM: %w", err)
+		}
+		c.dailyPostTime = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		return nil
+	}
+}
+
+// 今日の癒しに選んだ画像を保存しておくファイル。再起動や別のインスタンスでも同じ画像を返す
+func DailyStorePath(v string) Option {
+	return func(c *config) error {
+		c.dailyStorePath = v
+		return nil
+	}
+}
+
+// 設定すると POST /daily を Authorization: Bearer <token> 付きで呼んだときに投稿する。
+// Lambda では常駐できないので EventBridge などの外部スケジュールから呼ぶ。
+// 設定したときはプロセス内のタイマーでは投稿しない
+func DailyTriggerToken(v string) Option {
+	return func(c *config) error {
+		c.dailyTriggerToken = v
+		return nil
+	}
+}
+
+func ScoreStorePath(v string) Option {
+	return func(c *config) error {
+		c.scoreStorePath = v
+		return nil
+	}
+}
+
+func LikeReactions(v []string) Option {
+	return func(c *config) error {
+		c.likeReactions = v
+		return nil
+	}
+}
+
+func DislikeReactions(v []string) Option {
+	return func(c *config) error {
+		c.dislikeReactions = v
+		return nil
+	}
+}
+
+func PostLogPath(v string) Option {
+	return func(c *config) error {
+		c.postLogPath = v
+		return nil
+	}
+}
+
+func Admins(v []string) Option {
+	return func(c *config) error {
+		c.admins = v
+		return nil
+	}
+}
+
+func DeleteReactions(v []string) Option {
+	return func(c *config) error {
+		c.deleteReactions = v
+		return nil
+	}
+}
+
+func NegativeKeywords(v []string) Option {
+	return func(c *config) error {
+		c.negativeKeywords = v
+		return nil
+	}
+}
+
+func BlocklistPath(v string) Option {
+	return func(c *config) error {
+		c.blocklistPath = v
+		return nil
+	}
+}
+
+// safe / moderate / restricted のどれか。
+// Flickr の safe_search に渡すほか、safe では他のソースもタイトルや sensitive 指定で絞る
+func DefaultSafetyLevel(v string) Option {
+	return func(c *config) error {
+		c.defaultSafetyLevel = v
+		return nil
+	}
+}
+
+func ChannelSafetyLevels(v map[string]string) Option {
+	return func(c *config) error {
+		c.channelSafetyLevels = v
+		return nil
+	}
+}
+
+// flickr / tumblr などソース名ごとの外部 API の timeout
+func SourceTimeouts(v map[string]time.Duration) Option {
+	return func(c *config) error {
+		c.sourceTimeouts = v
+		return nil
+	}
+}
+
+// API キーの失効などを知らせるチャンネル
+func AlertChannel(v string) Option {
+	return func(c *config) error {
+		c.alertChannel = v
+		return nil
+	}
+}
+
+// flickr.photos.licenses.getInfo の id。空なら絞り込まない
+func FlickrLicenses(v []string) Option {
+	return func(c *config) error {
+		c.flickrLicenses = v
+		return nil
+	}
+}
+
+// flickr の検索条件を固定したコマンド
+type FlickrCommand struct {
+	MatchStrings []string
+	Help         string
+	Keywords     []string
+	Tags         []string
+	TagMode      string
+	Sort         string
+	GroupID      string
+	UserID       string
+	Size         string
+	Orientation  string
+	MinWidth     int
+	MinHeight    int
+}
+
+func FlickrCommands(v []FlickrCommand) Option {
+	return func(c *config) error {
+		for _, cmd := range v {
+			if len(cmd.MatchStrings) == 0 {
+				return fmt.Errorf("FlickrCommand MatchStrings required")
+			}
+		}
+		c.flickrCommands = v
+		return nil
+	}
+}
+
+// 地名 -> {"lat", "lon", "radius_km", "place_id"} の JSON
+func FlickrGazetteerPath(v string) Option {
+	return func(c *config) error {
+		c.flickrGazetteerPath = v
+		return nil
+	}
+}
+
+// 0 ならキャッシュしない
+func FlickrPageCountTTL(v time.Duration) Option {
+	return func(c *config) error {
+		c.flickrPageCountTTL = v
+		return nil
+	}
+}
+
+func FlickrPageTTL(v time.Duration) Option {
+	return func(c *config) error {
+		c.flickrPageTTL = v
+		return nil
+	}
+}
+
+// 空ならインデックスを作らない。毎回ブログを最初から読み直すことになるため
+func TumblrIndexDir(v string) Option {
+	return func(c *config) error {
+		c.tumblrIndexDir = v
+		return nil
+	}
+}
+
+// 0 ならインデックスを作らない。TumblrIndexDir も必要
+func TumblrIndexInterval(v time.Duration) Option {
+	return func(c *config) error {
+		c.tumblrIndexInterval = v
+		return nil
+	}
+}
+
+type FeedCommand struct {
+	MatchStrings []string
+	Help         string
+	URL          string
+	// 常に付けて絞り込むカテゴリ
+	Tags []string
+	IsDM bool
+}
+
+func FeedCommands(v []FeedCommand) Option {
+	return func(c *config) error {
+		for _, cmd := range v {
+			if len(cmd.MatchStrings) == 0 {
+				return fmt.Errorf("FeedCommand MatchStrings required")
+			}
+			if cmd.URL == "" {
+				return fmt.Errorf("FeedCommand URL required")
+			}
+		}
+		c.feedCommands = v
+		return nil
+	}
+}
+
+// 0 ならキャッシュしない
+func FeedTTL(v time.Duration) Option {
+	return func(c *config) error {
+		c.feedTTL = v
+		return nil
+	}
+}
+
+// 癒し --source=mastodon で使うインスタンス。例: https://mastodon.social
+func MastodonInstanceURL(v string) Option {
+	return func(c *config) error {
+		c.mastodonInstanceURL = v
+		return nil
+	}
+}
+
+// 公開タイムラインにログインが要るインスタンスだけ
+func MastodonAccessToken(v string) Option {
+	return func(c *config) error {
+		c.mastodonAccessToken = v
+		return nil
+	}
+}
+
+// センシティブ指定や CW 付きの投稿を除く
+func MastodonExcludeSensitive(v bool) Option {
+	return func(c *config) error {
+		c.mastodonExcludeSensitive = v
+		return nil
+	}
+}
+
+// 単語が指定されなかったときに使うハッシュタグ
+func MastodonDefaultHashtags(v []string) Option {
+	return func(c *config) error {
+		c.mastodonDefaultHashtags = v
+		return nil
+	}
+}
+
+// 0 ならキャッシュしない
+func MastodonTTL(v time.Duration) Option {
+	return func(c *config) error {
+		c.mastodonTTL = v
+		return nil
+	}
+}
+
+type RedditCommand struct {
+	MatchStrings []string
+	Help         string
+	Subreddit    string
+	// hot / new / top / rising。空なら hot
+	Sort string
+	IsDM bool
+}
+
+func RedditCommands(v []RedditCommand) Option {
+	return func(c *config) error {
+		for _, cmd := range v {
+			if len(cmd.MatchStrings) == 0 {
+				return fmt.Errorf("RedditCommand MatchStrings required")
+			}
+			if cmd.Subreddit == "" {
+				return fmt.Errorf("RedditCommand Subreddit required")
+			}
+			switch cmd.Sort {
+			case "", "hot", "new", "top", "rising":
+			default:
+				return fmt.Errorf("unknown RedditCommand Sort: %s", cmd.Sort)
+			}
+		}
+		c.redditCommands = v
+		return nil
+	}
+}
+
+// reddit は UA で利用者を見分けるので自分たちのものを名乗る
+func RedditUserAgent(v string) Option {
+	return func(c *config) error {
+		c.redditUserAgent = v
+		return nil
+	}
+}
+
+// 0 ならキャッシュしない
+func RedditTTL(v time.Duration) Option {
+	return func(c *config) error {
+		c.redditTTL = v
+		return nil
+	}
+}
+
+// "giphy" か "tenor"
+func GifProvider(v string) Option {
+	return func(c *config) error {
+		switch v {
+		case "giphy", "tenor":
+		default:
+			return fmt.Errorf("unknown GifProvider: %s", v)
+		}
+		c.gifProvider = v
+		return nil
+	}
+}
+
+func GifAPIKey(v string) Option {
+	return func(c *config) error {
+		c.gifAPIKey = v
+		return nil
+	}
+}
+
+// 空ならプロバイダの本家。互換 API や手元の偽物に向けるときに使う
+func GifAPIBaseURL(v string) Option {
+	return func(c *config) error {
+		c.gifAPIBaseURL = v
+		return nil
+	}
+}
+
+// g / pg / pg-13 / r。これより緩いものは返さない
+func GifRating(v string) Option {
+	return func(c *config) error {
+		switch v {
+		case "g", "pg", "pg-13", "r":
+		default:
+			return fmt.Errorf("unknown GifRating: %s", v)
+		}
+		c.gifRating = v
+		return nil
+	}
+}
+
+// 0 ならキャッシュしない
+func GifTTL(v time.Duration) Option {
+	return func(c *config) error {
+		c.gifTTL = v
+		return nil
+	}
+}
+
+// 0 ならキャッシュしない
+func CommonsTTL(v time.Duration) Option {
+	return func(c *config) error {
+		c.commonsTTL = v
+		return nil
+	}
+}
+
+// 0 ならキャッシュしない
+func EmojiTTL(v time.Duration) Option {
+	return func(c *config) error {
+		c.emojiTTL = v
+		return nil
+	}
+}
+
+// "danbooru" か "moebooru"。moebooru は BooruBaseURL も必要
+func BooruProvider(v string) Option {
+	return func(c *config) error {
+		switch v {
+		case "danbooru", "moebooru":
+		default:
+			return fmt.Errorf("unknown BooruProvider: %s", v)
+		}
+		c.booruProvider = v
+		return nil
+	}
+}
+
+// 空なら danbooru 本家。moebooru は既定が無いので yande.re などを必ず指定する
+func BooruBaseURL(v string) Option {
+	return func(c *config) error {
+		c.booruBaseURL = v
+		return nil
+	}
+}
+
+// moebooru なら apiKey には password_hash を入れる
+func BooruAuth(login, apiKey string) Option {
+	return func(c *config) error {
+		c.booruLogin = login
+		c.booruAPIKey = apiKey
+		return nil
+	}
+}
+
+// チャンネル ID -> general / sensitive / questionable。
+// 載っていないチャンネルでは常に general だけを返す
+func BooruChannelRatings(v map[string]string) Option {
+	return func(c *config) error {
+		for ch, r := range v {
+			switch r {
+			case "general", "sensitive", "questionable":
+			default:
+				return fmt.Errorf("unknown BooruChannelRatings rating: channel=%s %s", ch, r)
+			}
+		}
+		c.booruChannelRatings = v
+		return nil
+	}
+}
+
+// このタグが付いた投稿は返さない
+func BooruBlacklist(v []string) Option {
+	return func(c *config) error {
+		c.booruBlacklist = v
+		return nil
+	}
+}
+
 type Config interface {
 	SlackBotToken() string
 	SlackSigningSecret() string
@@ -70,16 +598,130 @@ type Config interface {
 	TumblrAPIToken() string
 	MoeURL() string
 	MoeKeys() []string
+	MoeManifestURL() string
+	MoeRefreshInterval() time.Duration
+	MoeUpload() bool
+	MoeURLSigning() URLSigning
+	ImageUploadMaxBytes() int64
+	ImageFetchAuth() map[string]string
+	DictionaryPath() string
+	Location() *time.Location
+	DailyChannel() string
+	DailyPostTime() time.Duration
+	DailyStorePath() string
+	DailyTriggerToken() string
+	ScoreStorePath() string
+	LikeReactions() []string
+	DislikeReactions() []string
+	PostLogPath() string
+	Admins() []string
+	DeleteReactions() []string
+	NegativeKeywords() []string
+	BlocklistPath() string
+	DefaultSafetyLevel() string
+	ChannelSafetyLevels() map[string]string
+	SourceTimeout(source string) time.Duration
+	AlertChannel() string
+	FlickrLicenses() []string
+	FlickrCommands() []FlickrCommand
+	FlickrGazetteerPath() string
+	FlickrPageCountTTL() time.Duration
+	FlickrPageTTL() time.Duration
+	TumblrIndexDir() string
+	TumblrIndexInterval() time.Duration
+	FeedCommands() []FeedCommand
+	FeedTTL() time.Duration
+	MastodonInstanceURL() string
+	MastodonAccessToken() string
+	MastodonExcludeSensitive() bool
+	MastodonDefaultHashtags() []string
+	MastodonTTL() time.Duration
+	RedditCommands() []RedditCommand
+	RedditUserAgent() string
+	RedditTTL() time.Duration
+	GifProvider() string
+	GifAPIKey() string
+	GifAPIBaseURL() string
+	GifRating() string
+	GifTTL() time.Duration
+	CommonsTTL() time.Duration
+	EmojiTTL() time.Duration
+	BooruProvider() string
+	BooruBaseURL() string
+	BooruLogin() string
+	BooruAPIKey() string
+	BooruChannelRatings() map[string]string
+	BooruBlacklist() []string
 	Valid() error
 }
 
 type config struct {
-	slackBotToken      string
-	slackSigningSecret string
-	flickrAPIToken     string
-	tumblrAPIToken     string
-	moeURL             string
-	moeKeys            []string
+	slackBotToken       string
+	slackSigningSecret  string
+	flickrAPIToken      string
+	tumblrAPIToken      string
+	moeURL              string
+	moeKeys             []string
+	moeManifestURL      string
+	moeRefreshInterval  time.Duration
+	moeUpload           bool
+	moeURLSigning       URLSigning
+	imageUploadMaxBytes int64
+	imageFetchAuth      map[string]string
+	dictionaryPath      string
+	location            *time.Location
+	dailyChannel        string
+	dailyPostTime       time.Duration
+	dailyStorePath      string
+	dailyTriggerToken   string
+	scoreStorePath      string
+	likeReactions       []string
+	dislikeReactions    []string
+	postLogPath         string
+	admins              []string
+	deleteReactions     []string
+	negativeKeywords    []string
+	blocklistPath       string
+	defaultSafetyLevel  string
+	channelSafetyLevels map[string]string
+	sourceTimeouts      map[string]time.Duration
+	alertChannel        string
+	flickrLicenses      []string
+	flickrCommands      []FlickrCommand
+	flickrGazetteerPath string
+	flickrPageCountTTL  time.Duration
+	flickrPageTTL       time.Duration
+	tumblrIndexDir      string
+	tumblrIndexInterval time.Duration
+	feedCommands        []FeedCommand
+	feedTTL             time.Duration
+
+	mastodonInstanceURL      string
+	mastodonAccessToken      string
+	mastodonExcludeSensitive bool
+	mastodonDefaultHashtags  []string
+	mastodonTTL              time.Duration
+
+	redditCommands  []RedditCommand
+	redditUserAgent string
+	redditTTL       time.Duration
+
+	gifProvider   string
+	gifAPIKey     string
+	gifAPIBaseURL string
+	gifRating     string
+	gifTTL        time.Duration
+
+	commonsTTL time.Duration
+
+	emojiTTL time.Duration
+
+	booruProvider       string
+	booruBaseURL        string
+	booruLogin          string
+	booruAPIKey         string
+	booruChannelRatings map[string]string
+	booruBlacklist      []string
 }
 
 func (c *config) SlackBotToken() string {
@@ -106,6 +748,222 @@ func (c *config) MoeKeys() []string {
 	return c.moeKeys
 }
 
+func (c *config) MoeManifestURL() string {
+	return c.moeManifestURL
+}
+
+func (c *config) MoeRefreshInterval() time.Duration {
+	return c.moeRefreshInterval
+}
+
+func (c *config) MoeUpload() bool {
+	return c.moeUpload
+}
+
+func (c *config) MoeURLSigning() URLSigning {
+	return c.moeURLSigning
+}
+
+func (c *config) ImageUploadMaxBytes() int64 {
+	return c.imageUploadMaxBytes
+}
+
+func (c *config) ImageFetchAuth() map[string]string {
+	return c.imageFetchAuth
+}
+
+func (c *config) DictionaryPath() string {
+	return c.dictionaryPath
+}
+
+func (c *config) Location() *time.Location {
+	return c.location
+}
+
+func (c *config) DailyChannel() string {
+	return c.dailyChannel
+}
+
+func (c *config) DailyPostTime() time.Duration {
+	return c.dailyPostTime
+}
+
+func (c *config) DailyStorePath() string {
+	return c.dailyStorePath
+}
+
+func (c *config) DailyTriggerToken() string {
+	return c.dailyTriggerToken
+}
+
+func (c *config) ScoreStorePath() string {
+	return c.scoreStorePath
+}
+
+func (c *config) LikeReactions() []string {
+	return c.likeReactions
+}
+
+func (c *config) DislikeReactions() []string {
+	return c.dislikeReactions
+}
+
+func (c *config) PostLogPath() string {
+	return c.postLogPath
+}
+
+func (c *config) Admins() []string {
+	return c.admins
+}
+
+func (c *config) DeleteReactions() []string {
+	return c.deleteReactions
+}
+
+func (c *config) NegativeKeywords() []string {
+	return c.negativeKeywords
+}
+
+func (c *config) BlocklistPath() string {
+	return c.blocklistPath
+}
+
+func (c *config) DefaultSafetyLevel() string {
+	return c.defaultSafetyLevel
+}
+
+func (c *config) ChannelSafetyLevels() map[string]string {
+	return c.channelSafetyLevels
+}
+
+func (c *config) SourceTimeout(source string) time.Duration {
+	return c.sourceTimeouts[source]
+}
+
+func (c *config) AlertChannel() string {
+	return c.alertChannel
+}
+
+func (c *config) FlickrLicenses() []string {
+	return c.flickrLicenses
+}
+
+func (c *config) FlickrCommands() []FlickrCommand {
+	return c.flickrCommands
+}
+
+func (c *config) FlickrGazetteerPath() string {
+	return c.flickrGazetteerPath
+}
+
+func (c *config) FlickrPageCountTTL() time.Duration {
+	return c.flickrPageCountTTL
+}
+
+func (c *config) FlickrPageTTL() time.Duration {
+	return c.flickrPageTTL
+}
+
+func (c *config) TumblrIndexDir() string {
+	return c.tumblrIndexDir
+}
+
+func (c *config) TumblrIndexInterval() time.Duration {
+	return c.tumblrIndexInterval
+}
+
+func (c *config) FeedCommands() []FeedCommand {
+	return c.feedCommands
+}
+
+func (c *config) FeedTTL() time.Duration {
+	return c.feedTTL
+}
+
+func (c *config) MastodonInstanceURL() string {
+	return c.mastodonInstanceURL
+}
+
+func (c *config) MastodonAccessToken() string {
+	return c.mastodonAccessToken
+}
+
+func (c *config) MastodonExcludeSensitive() bool {
+	return c.mastodonExcludeSensitive
+}
+
+func (c *config) MastodonDefaultHashtags() []string {
+	return c.mastodonDefaultHashtags
+}
+
+func (c *config) MastodonTTL() time.Duration {
+	return c.mastodonTTL
+}
+
+func (c *config) RedditCommands() []RedditCommand {
+	return c.redditCommands
+}
+
+func (c *config) RedditUserAgent() string {
+	return c.redditUserAgent
+}
+
+func (c *config) RedditTTL() time.Duration {
+	return c.redditTTL
+}
+
+func (c *config) GifProvider() string {
+	return c.gifProvider
+}
+
+func (c *config) GifAPIKey() string {
+	return c.gifAPIKey
+}
+
+func (c *config) GifAPIBaseURL() string {
+	return c.gifAPIBaseURL
+}
+
+func (c *config) GifRating() string {
+	return c.gifRating
+}
+
+func (c *config) GifTTL() time.Duration {
+	return c.gifTTL
+}
+
+func (c *config) CommonsTTL() time.Duration {
+	return c.commonsTTL
+}
+
+func (c *config) EmojiTTL() time.Duration {
+	return c.emojiTTL
+}
+
+func (c *config) BooruProvider() string {
+	return c.booruProvider
+}
+
+func (c *config) BooruBaseURL() string {
+	return c.booruBaseURL
+}
+
+func (c *config) BooruLogin() string {
+	return c.booruLogin
+}
+
+func (c *config) BooruAPIKey() string {
+	return c.booruAPIKey
+}
+
+func (c *config) BooruChannelRatings() map[string]string {
+	return c.booruChannelRatings
+}
+
+func (c *config) BooruBlacklist() []string {
+	return c.booruBlacklist
+}
+
 func (c *config) Valid() error {
 	if c.slackBotToken == "" {
 		return fmt.Errorf("SlackBotToken required")
@@ -126,11 +984,46 @@ func (c *config) Valid() error {
 }
 
 func NewConfig(opts ...Option) (Config, error) {
-	c := &config{}
+	c := &config{
+		location:            time.Local,
+		moeRefreshInterval:  10 * time.Minute,
+		imageUploadMaxBytes: 5 << 20,
+		dailyPostTime:       9 * time.Hour,
+		likeReactions:       []string{"+1", "thumbsup"},
+		dislikeReactions:    []string{"-1", "thumbsdown"},
+		deleteReactions:     []string{"wastebasket"},
+		negativeKeywords:    []string{"hentai", "porn", "sexy", "fuck"},
+		defaultSafetyLevel:  "safe",
+		flickrPageCountTTL:  time.Hour,
+		flickrPageTTL:       10 * time.Minute,
+		tumblrIndexInterval: time.Hour,
+		feedTTL:             30 * time.Minute,
+
+		mastodonExcludeSensitive: true,
+		mastodonDefaultHashtags:  []string{"caturday", "dogsofmastodon"},
+		mastodonTTL:              5 * time.Minute,
+
+		redditTTL: 15 * time.Minute,
+
+		gifProvider: "giphy",
+		gifRating:   "g",
+		gifTTL:      time.Hour,
+
+		commonsTTL: time.Hour,
+
+		emojiTTL: time.Hour,
+
+		booruProvider:  "danbooru",
+		booruBlacklist: []string{"nude", "underwear", "swimsuit", "bikini", "cleavage", "blood", "guro", "gore"},
+	}
 	for _, opt := range opts {
 		if err := opt(c); err != nil {
 			return nil, err
 		}
 	}
+	// オプションの順番に関係なく見たいのでまとめて最後に確かめる
+	if c.booruProvider == "moebooru" && c.booruBaseURL == "" {
+		return nil, fmt.Errorf("BooruBaseURL is required for BooruProvider: moebooru")
+	}
 	return c, nil
 }
@@ -1 +1,57 @@
 package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Image struct {
+	URL string
+	// 期限付きで署名した URL。評価やブロックは URL で行い、Slack にはこちらを貼る
+	SignedURL string `json:"-"`
+	Source    string
+	Title     string
+	Author    string
+	Link      string
+	License   string
+	Width     int
+	Height    int
+	Tags      []string
+	// サイズ違いの URL でも同じ写真だとわかるように、ソース側の ID と投稿者を持っておく
+	PhotoID string
+	Owner   string
+	// 指定が無くて searcher が選んだ検索ワード。評価はこのワードに付ける
+	Query string `json:"-"`
+}
+
+func (i *Image) DeliveryURL() string {
+	if i.SignedURL != "" {
+		return i.SignedURL
+	}
+	return i.URL
+}
+
+// Slack のメッセージでは & < > が制御文字になるのでエスケープする
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// 画像の下に添えるクレジット。わかっている項目だけ並べる。
+// タイトルなどは外部の人が付けたものなので、<!channel> などで通知が飛ばないようにエスケープしておく
+func (i *Image) Attribution() string {
+	parts := make([]string, 0, 4)
+	if i.Title != "" {
+		parts = append(parts, fmt.Sprintf("「%s」", slackEscaper.Replace(i.Title)))
+	}
+	if i.Author != "" {
+		parts = append(parts, fmt.Sprintf("by %s", slackEscaper.Replace(i.Author)))
+	}
+	if i.License != "" {
+		parts = append(parts, fmt.Sprintf("(%s)", slackEscaper.Replace(i.License)))
+	}
+	if i.Link != "" {
+		parts = append(parts, slackEscaper.Replace(i.Link))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s", slackEscaper.Replace(i.Source), strings.Join(parts, " "))
+}
@@ -0,0 +1,45 @@
+package domain
+
+import "testing"
+
+func TestAttribution(t *testing.T) {
+	tests := []struct {
+		name  string
+		image Image
+		want  string
+	}{
+		{
+			name:  "empty",
+			image: Image{URL: "https://example.com/a.jpg", Source: "flickr"},
+			want:  "",
+		},
+		{
+			name: "all fields",
+			image: Image{
+				Source:  "flickr",
+				Title:   "tama",
+				Author:  "alice",
+				License: "CC BY 2.0",
+				Link:    "https://www.flickr.com/photos/alice/1",
+			},
+			want: "flickr: 「tama」 by alice (CC BY 2.0) https://www.flickr.com/photos/alice/1",
+		},
+		{
+			name: "mentions and control characters are escaped",
+			image: Image{
+				Source: "reddit",
+				Title:  "<!channel> cats & dogs",
+				Author: "<@U123>",
+				Link:   "https://example.com/?a=1&b=<2>",
+			},
+			want: "reddit: 「&lt;!channel&gt; cats &amp; dogs」 by &lt;@U123&gt; https://example.com/?a=1&amp;b=&lt;2&gt;",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.image.Attribution(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
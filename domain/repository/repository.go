@@ -1,6 +1,10 @@
 package repository
 
-import "context"
+import (
+	"context"
+
+	"github.com/mix3/iyashi-bot/domain"
+)
 
 type RepositoryError string
 
@@ -9,43 +13,319 @@ func (r RepositoryError) Error() string {
 }
 
 const (
-	ErrorNotFound RepositoryError = "ErrorNotFound"
+	ErrorNotFound            RepositoryError = "ErrorNotFound"
+	ErrorInvalidAPIKey       RepositoryError = "ErrorInvalidAPIKey"
+	ErrorRateLimited         RepositoryError = "ErrorRateLimited"
+	ErrorBlogNotFound        RepositoryError = "ErrorBlogNotFound"
+	ErrorUpstreamUnavailable RepositoryError = "ErrorUpstreamUnavailable"
+	ErrorImageTooLarge       RepositoryError = "ErrorImageTooLarge"
+	ErrorUnsupportedImage    RepositoryError = "ErrorUnsupportedImage"
 )
 
 type Repository interface {
 	SlackAPI() SlackAPI
+	Random() Random
 	FlickrSearcher() FlickrSearcher
 	TumblrSearcher() TumblrSearcher
 	MoeSearcher() MoeSearcher
+	FeedSearcher() FeedSearcher
+	MastodonSearcher() MastodonSearcher
+	RedditSearcher() RedditSearcher
+	GifSearcher() GifSearcher
+	CommonsSearcher() CommonsSearcher
+	BooruSearcher() BooruSearcher
+	ImageFetcher() ImageFetcher
+	Dictionary() Dictionary
+	ScoreStore() ScoreStore
+	PostLog() PostLog
+	DailyStore() DailyStore
+	Safety() Safety
+	CacheStats() []CacheStats
+}
+
+type CacheStats struct {
+	Name    string `json:"name"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+type Message struct {
+	Channel string
+	TS      string
+	// アップロードした画像。メッセージを消してもファイルはワークスペースに残る
+	FileID string
+}
+
+// Slack にアップロードするためにダウンロードした画像
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
 }
 
 type SlackAPI interface {
-	DirectMessage(ctx context.Context, user, text string) error
-	PostMessage(ctx context.Context, channel, text string) error
-	Reply(ctx context.Context, channel, user, text string) error
+	DirectMessage(ctx context.Context, user, text string) (Message, error)
+	PostMessage(ctx context.Context, channel, text string) (Message, error)
+	Reply(ctx context.Context, channel, user, text string) (Message, error)
+	UploadFile(ctx context.Context, channel, comment string, file File) (Message, error)
+	DirectUploadFile(ctx context.Context, user, comment string, file File) (Message, error)
+	ReplyImageBlock(ctx context.Context, channel, user, text, imageURL, altText string) (Message, error)
+	DeleteMessage(ctx context.Context, channel, ts string) error
+	DeleteFile(ctx context.Context, fileID string) error
+	ListEmoji(ctx context.Context) ([]Emoji, error)
 	UserID() string
+	CacheStats() []CacheStats
+}
+
+// ワークスペースのカスタム絵文字
+type Emoji struct {
+	Name string
+	URL  string
+}
+
+// Slack から見えない場所にある画像を bot が代わりに取ってくる
+type ImageFetcher interface {
+	Fetch(ctx context.Context, imageURL string) (File, error)
 }
 
 type FlickrSearcher interface {
-	RandomSearch(ctx context.Context, keywords []string) (FlickrRandomSearchResponse, error)
+	RandomSearch(ctx context.Context, query FlickrQuery) (*domain.Image, error)
+	CacheStats() []CacheStats
+}
+
+const (
+	FlickrSizeMedium = "z"
+	FlickrSizeLarge  = "b"
+	FlickrSizeHuge   = "h"
+
+	OrientationLandscape = "landscape"
+	OrientationPortrait  = "portrait"
+	OrientationSquare    = "square"
+)
+
+type FlickrQuery struct {
+	Keywords    []string
+	Tags        []string
+	TagMode     string
+	Sort        string
+	GroupID     string
+	UserID      string
+	Place       string
+	RadiusKm    float64
+	Size        string
+	Orientation string
+	MinWidth    int
+	MinHeight   int
 }
 
-type FlickrRandomSearchResponse interface {
-	ImageURL() string
+// キーワード以外で絞り込まれているか。
+// 地名だけの検索は flickr が直近 12 時間の写真しか返さないので数えない
+func (q FlickrQuery) Scoped() bool {
+	return 0 < len(q.Tags) || q.GroupID != "" || q.UserID != ""
 }
 
 type TumblrSearcher interface {
-	RandomSearch(ctx context.Context, tumblrID string, tags []string) (TumblrRandomSearchResponse, error)
+	Watch(tumblrID string)
+	RandomSearch(ctx context.Context, tumblrID string, tags []string) (*domain.Image, error)
+	// 投稿をひとつ選んで、その投稿の画像をすべて返す
+	RandomPhotoset(ctx context.Context, tumblrID string, tags []string) ([]*domain.Image, error)
+}
+
+// RSS / Atom の enclosure や media:content、本文の img から画像を選ぶ
+type FeedSearcher interface {
+	RandomSearch(ctx context.Context, feedURL string, tags []string) (*domain.Image, error)
+	CacheStats() []CacheStats
+}
+
+// インスタンスの公開ハッシュタグタイムラインから画像を選ぶ。
+// hashtags が複数あればどれかひとつを選んで探す
+type MastodonSearcher interface {
+	RandomSearch(ctx context.Context, hashtags []string) (*domain.Image, error)
+	CacheStats() []CacheStats
+}
+
+// subreddit の一覧から画像の投稿を選ぶ。sort は hot / new / top / rising
+type RedditSearcher interface {
+	RandomSearch(ctx context.Context, subreddit, sort string) (*domain.Image, error)
+	CacheStats() []CacheStats
+}
+
+// Giphy / Tenor 互換の検索 API からアニメーション GIF を選ぶ
+type GifSearcher interface {
+	RandomSearch(ctx context.Context, keywords []string) (*domain.Image, error)
+	CacheStats() []CacheStats
+}
+
+// Wikimedia Commons から作者とライセンスのわかる画像だけを選ぶ
+type CommonsSearcher interface {
+	RandomSearch(ctx context.Context, keywords []string) (*domain.Image, error)
+	CacheStats() []CacheStats
+}
+
+// イラストの rating。explicit は扱わない
+type BooruRating int
+
+const (
+	BooruRatingGeneral BooruRating = iota + 1
+	BooruRatingSensitive
+	BooruRatingQuestionable
+)
+
+func ParseBooruRating(v string) (BooruRating, bool) {
+	switch v {
+	case "general":
+		return BooruRatingGeneral, true
+	case "sensitive":
+		return BooruRatingSensitive, true
+	case "questionable":
+		return BooruRatingQuestionable, true
+	}
+	return 0, false
+}
+
+func (r BooruRating) String() string {
+	switch r {
+	case BooruRatingSensitive:
+		return "sensitive"
+	case BooruRatingQuestionable:
+		return "questionable"
+	}
+	return "general"
 }
 
-type TumblrRandomSearchResponse interface {
-	PhotoURL() string
+type BooruQuery struct {
+	Tags   []string
+	Rating BooruRating
+}
+
+// Danbooru / Moebooru 互換の画像掲示板からタグで選ぶ
+type BooruSearcher interface {
+	RandomSearch(ctx context.Context, query BooruQuery) (*domain.Image, error)
 }
 
 type MoeSearcher interface {
-	RandomSearch(ctx context.Context) (MoeRandomSearchResponse, error)
+	// tags が空なら全体から選ぶ
+	RandomSearch(ctx context.Context, tags []string) (*domain.Image, error)
+	// Moe の URL なら期限付きの URL にする。それ以外はそのまま返す
+	SignURL(rawURL string) (string, error)
+}
+
+type Dictionary interface {
+	Expand(keyword string) []string
+	Translate(keyword string) []string
+}
+
+type Random interface {
+	Intn(n int) int
+}
+
+type randomKey struct{}
+
+func WithRandom(ctx context.Context, r Random) context.Context {
+	return context.WithValue(ctx, randomKey{}, r)
+}
+
+func RandomFromContext(ctx context.Context) (Random, bool) {
+	r, ok := ctx.Value(randomKey{}).(Random)
+	return r, ok
+}
+
+type ScoredImage struct {
+	ImageURL string `json:"image_url"`
+	Score    int    `json:"score"`
+}
+
+type ScoreStore interface {
+	AddScore(imageURL, query string, delta int) error
+	Score(imageURL string) int
+	QueryScore(query string) int
+	Top(n int) []ScoredImage
+}
+
+type Post struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+	// DM で返したときも、どのチャンネルで頼まれたかわかるように残す
+	RequestChannel string   `json:"request_channel,omitempty"`
+	User           string   `json:"user"`
+	ImageURL       string   `json:"image_url,omitempty"`
+	PhotoID        string   `json:"photo_id,omitempty"`
+	Owner          string   `json:"owner,omitempty"`
+	FileID         string   `json:"file_id,omitempty"`
+	Query          string   `json:"query,omitempty"`
+	Args           []string `json:"args,omitempty"`
+}
+
+// 今日の癒しに選んだ画像と、チャンネルに投稿済みかどうか
+type DailyPick struct {
+	Day    string        `json:"day"`
+	Image  *domain.Image `json:"image"`
+	Posted bool          `json:"posted"`
+}
+
+type DailyStore interface {
+	Get() (DailyPick, bool)
+	Save(pick DailyPick) error
+}
+
+type PostLog interface {
+	Record(post Post) error
+	Find(channel, ts string) (Post, bool)
+	LastImage(channel, user string) (Post, bool)
+	// channel で頼まれた最後の画像。DM で返したものも含む
+	LastRequestedImage(channel string) (Post, bool)
+	Remove(channel, ts string) error
+}
+
+type SafetyLevel int
+
+const (
+	SafetyLevelSafe SafetyLevel = iota + 1
+	SafetyLevelModerate
+	SafetyLevelRestricted
+)
+
+func ParseSafetyLevel(v string) (SafetyLevel, bool) {
+	switch v {
+	case "safe":
+		return SafetyLevelSafe, true
+	case "moderate":
+		return SafetyLevelModerate, true
+	case "restricted":
+		return SafetyLevelRestricted, true
+	}
+	return 0, false
+}
+
+func (l SafetyLevel) String() string {
+	switch l {
+	case SafetyLevelModerate:
+		return "moderate"
+	case SafetyLevelRestricted:
+		return "restricted"
+	}
+	return "safe"
+}
+
+type safetyLevelKey struct{}
+
+func WithSafetyLevel(ctx context.Context, level SafetyLevel) context.Context {
+	return context.WithValue(ctx, safetyLevelKey{}, level)
+}
+
+// 指定がなければ一番厳しいレベルにする
+func SafetyLevelFromContext(ctx context.Context) SafetyLevel {
+	if l, ok := ctx.Value(safetyLevelKey{}).(SafetyLevel); ok {
+		return l
+	}
+	return SafetyLevelSafe
 }
 
-type MoeRandomSearchResponse interface {
-	ImageURL() string
+type Safety interface {
+	Level(channel string) SafetyLevel
+	NegativeKeywords() []string
+	IsBlocked(url, photoID, owner string) bool
+	BlockURL(url string) error
+	BlockPhotoID(photoID string) error
 }
@@ -3,13 +3,14 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	iyashibot "github.com/mix3/iyashi-bot"
 	"github.com/mix3/iyashi-bot/config"
 )
 
 func main() {
-	log.Fatal(iyashibot.Run(
+	opts := []config.Option{
 		config.SlackBotToken(os.Getenv("IYASHI_BOT_SLACK_BOT_TOKEN")),
 		config.SlackSigningSecret(os.Getenv("IYASHI_BOT_SLACK_SIGNING_SECRET")),
 		config.FlickrAPIToken(os.Getenv("IYASHI_BOT_FLICKR_API_TOKEN")),
@@ -20,5 +21,80 @@ func main() {
 			"yyy",
 			"zzz",
 		}),
-	))
+		config.MoeManifestURL(os.Getenv("IYASHI_BOT_MOE_MANIFEST_URL")),
+		config.MoeRefreshInterval(10 * time.Minute),
+		config.MoeUpload(false),
+		config.ImageUploadMaxBytes(5 << 20),
+		config.ImageFetchAuth(map[string]string{}),
+		config.DictionaryPath(os.Getenv("IYASHI_BOT_DICTIONARY_PATH")),
+		config.Timezone("Asia/Tokyo"),
+		config.DailyChannel(os.Getenv("IYASHI_BOT_DAILY_CHANNEL")),
+		config.DailyPostTime("09:00"),
+		config.DailyStorePath(os.Getenv("IYASHI_BOT_DAILY_STORE_PATH")),
+		config.DailyTriggerToken(os.Getenv("IYASHI_BOT_DAILY_TRIGGER_TOKEN")),
+		config.ScoreStorePath(os.Getenv("IYASHI_BOT_SCORE_STORE_PATH")),
+		config.PostLogPath(os.Getenv("IYASHI_BOT_POST_LOG_PATH")),
+		config.Admins([]string{}),
+		config.BlocklistPath(os.Getenv("IYASHI_BOT_BLOCKLIST_PATH")),
+		config.DefaultSafetyLevel("safe"),
+		config.ChannelSafetyLevels(map[string]string{}),
+		config.FlickrLicenses([]string{"4", "5", "9", "10", "11", "12"}),
+		config.FlickrCommands([]config.FlickrCommand{
+			{
+				MatchStrings: []string{"社猫"},
+				Help:         "会社の猫グループから画像を返すよ！",
+				GroupID:      "00000000@N00",
+				Sort:         "interestingness-desc",
+			},
+		}),
+		config.FlickrGazetteerPath(os.Getenv("IYASHI_BOT_FLICKR_GAZETTEER_PATH")),
+		config.TumblrIndexDir(os.Getenv("IYASHI_BOT_TUMBLR_INDEX_DIR")),
+		config.TumblrIndexInterval(time.Hour),
+		config.FeedCommands([]config.FeedCommand{
+			{
+				MatchStrings: []string{"ふぃーど"},
+				Help:         "フィードから画像を返すよ！",
+				URL:          "https://example.com/feed.xml",
+			},
+		}),
+		config.FeedTTL(30 * time.Minute),
+		config.MastodonInstanceURL("https://mastodon.social"),
+		config.MastodonExcludeSensitive(true),
+		config.MastodonDefaultHashtags([]string{"caturday", "dogsofmastodon"}),
+		config.RedditCommands([]config.RedditCommand{
+			{MatchStrings: []string{"あわ"}, Subreddit: "aww"},
+			{MatchStrings: []string{"目の保養"}, Subreddit: "Eyebleach", Sort: "top"},
+		}),
+		config.RedditUserAgent("iyashi-bot/1.0 (by /u/example)"),
+		config.GifProvider("giphy"),
+		config.GifAPIKey(os.Getenv("IYASHI_BOT_GIPHY_API_KEY")),
+		config.GifRating("g"),
+		config.EmojiTTL(time.Hour),
+		config.BooruProvider("danbooru"),
+		config.BooruChannelRatings(map[string]string{}),
+		config.BooruBlacklist([]string{"nude", "underwear", "swimsuit", "bikini", "cleavage", "blood", "guro", "gore"}),
+		config.AlertChannel(os.Getenv("IYASHI_BOT_ALERT_CHANNEL")),
+		config.SourceTimeouts(map[string]time.Duration{
+			"flickr":   5 * time.Second,
+			"tumblr":   5 * time.Second,
+			"moe":      5 * time.Second,
+			"fetch":    10 * time.Second,
+			"feed":     5 * time.Second,
+			"mastodon": 5 * time.Second,
+			"reddit":   5 * time.Second,
+			"gif":      5 * time.Second,
+			"commons":  5 * time.Second,
+			"booru":    5 * time.Second,
+			"slack":    30 * time.Second,
+		}),
+	}
+	// secret を渡したときだけ Moe の URL に署名する
+	if secret := os.Getenv("IYASHI_BOT_MOE_SIGNING_SECRET"); secret != "" {
+		opts = append(opts, config.MoeURLSigning(config.URLSigning{
+			Scheme: "hmac",
+			Secret: secret,
+			Expiry: 24 * time.Hour,
+		}))
+	}
+	log.Fatal(iyashibot.Run(opts...))
 }
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"io"
 	"log"
@@ -22,17 +23,21 @@ var (
 
 type Handler interface {
 	Index(w http.ResponseWriter, r *http.Request)
+	Stats(w http.ResponseWriter, r *http.Request)
+	Daily(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
-	signingSecret string
-	usecase       usecase.Usecase
+	signingSecret     string
+	dailyTriggerToken string
+	usecase           usecase.Usecase
 }
 
 func NewHandler(conf config.Config, u usecase.Usecase) Handler {
 	return &handler{
-		signingSecret: conf.SlackSigningSecret(),
-		usecase:       u,
+		signingSecret:     conf.SlackSigningSecret(),
+		dailyTriggerToken: conf.DailyTriggerToken(),
+		usecase:           u,
 	}
 }
 
@@ -99,10 +104,44 @@ func (h *handler) Index(w http.ResponseWriter, r *http.Request) {
 			}
 			log.Printf("[INFO] Run args=%v", args)
 			h.usecase.Run(r.Context(), ev.Channel, ev.User, args)
+		case *slackevents.ReactionAddedEvent:
+			h.usecase.React(r.Context(), ev.Item.Channel, ev.Item.Timestamp, ev.User, ev.Reaction, true)
+		case *slackevents.ReactionRemovedEvent:
+			h.usecase.React(r.Context(), ev.Item.Channel, ev.Item.Timestamp, ev.User, ev.Reaction, false)
 		}
 	}
 }
 
+// 監視用にキャッシュのヒット率などを返す
+func (h *handler) Stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.usecase.CacheStats()); err != nil {
+		log.Printf("[ERROR] %s", err)
+	}
+}
+
+// 外部のスケジュールから今日の癒しを投稿させる。token を設定していなければ使えない
+func (h *handler) Daily(w http.ResponseWriter, r *http.Request) {
+	if h.dailyTriggerToken == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.dailyTriggerToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err := h.usecase.PostDaily(r.Context()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 type Msg struct {
 	Event struct {
 		Edited *struct{} `json:"edited,omitempty"`
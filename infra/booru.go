@@ -0,0 +1,219 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	booruProviderDanbooru = "danbooru"
+	booruProviderMoebooru = "moebooru"
+
+	booruPageLimit = 50
+)
+
+var (
+	booruDefaultBaseURLs = map[string]string{
+		booruProviderDanbooru: "https://danbooru.donmai.us",
+	}
+
+	// API 上の rating の値。moebooru には general が無く safe が一番ゆるくない
+	booruRatingValues = map[string]map[repository.BooruRating]string{
+		booruProviderDanbooru: {
+			repository.BooruRatingGeneral:      "g",
+			repository.BooruRatingSensitive:    "s",
+			repository.BooruRatingQuestionable: "q",
+		},
+		booruProviderMoebooru: {
+			repository.BooruRatingGeneral:      "s",
+			repository.BooruRatingSensitive:    "s",
+			repository.BooruRatingQuestionable: "q",
+		},
+	}
+)
+
+// Danbooru と Moebooru (yande.re / konachan) 互換の API を叩く
+type booruSearcher struct {
+	provider  string
+	baseURL   string
+	login     string
+	apiKey    string
+	blacklist []string
+	client    *httpClient
+	random    repository.Random
+	scores    repository.ScoreStore
+	safety    repository.Safety
+}
+
+func newBooruSearcher(provider, baseURL, login, apiKey string, blacklist []string, client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety) repository.BooruSearcher {
+	if provider == "" {
+		provider = booruProviderDanbooru
+	}
+	if baseURL == "" {
+		baseURL = booruDefaultBaseURLs[provider]
+	}
+	return &booruSearcher{
+		provider:  provider,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		login:     login,
+		apiKey:    apiKey,
+		blacklist: blacklist,
+		client:    client,
+		random:    random,
+		scores:    scores,
+		safety:    safety,
+	}
+}
+
+// 両方の API の項目をまとめて受ける
+type booruPost struct {
+	ID       int    `json:"id"`
+	FileURL  string `json:"file_url"`
+	Rating   string `json:"rating"`
+	Source   string `json:"source"`
+	IsBanned bool   `json:"is_banned"`
+	// danbooru
+	LargeFileURL string `json:"large_file_url"`
+	TagString    string `json:"tag_string"`
+	Artists      string `json:"tag_string_artist"`
+	Characters   string `json:"tag_string_character"`
+	ImageWidth   int    `json:"image_width"`
+	ImageHeight  int    `json:"image_height"`
+	// moebooru
+	SampleURL string `json:"sample_url"`
+	Tags      string `json:"tags"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+func (p *booruPost) tags() []string {
+	if p.TagString != "" {
+		return strings.Fields(p.TagString)
+	}
+	return strings.Fields(p.Tags)
+}
+
+func (b *booruSearcher) postURL(id int) string {
+	if b.provider == booruProviderMoebooru {
+		return fmt.Sprintf("%s/post/show/%d", b.baseURL, id)
+	}
+	return fmt.Sprintf("%s/posts/%d", b.baseURL, id)
+}
+
+// 作者と出典がわかるように、artist タグと元の source を優先して載せる
+func (b *booruSearcher) image(p *booruPost) *domain.Image {
+	u, width, height := p.LargeFileURL, p.ImageWidth, p.ImageHeight
+	if u == "" {
+		u = p.SampleURL
+	}
+	if u == "" {
+		u = p.FileURL
+	}
+	if width == 0 {
+		width, height = p.Width, p.Height
+	}
+	link := b.postURL(p.ID)
+	if strings.HasPrefix(p.Source, "http://") || strings.HasPrefix(p.Source, "https://") {
+		link = p.Source
+	}
+	return &domain.Image{
+		URL:    u,
+		Source: b.provider,
+		Title:  strings.ReplaceAll(p.Characters, "_", " "),
+		Author: strings.ReplaceAll(p.Artists, "_", " "),
+		Link:   link,
+		Width:  width,
+		Height: height,
+		Tags:   p.tags(),
+	}
+}
+
+func (b *booruSearcher) blacklisted(tags []string) bool {
+	for _, t := range tags {
+		for _, ng := range b.blacklist {
+			if strings.EqualFold(t, ng) {
+				return true
+			}
+		}
+	}
+	return containsNegativeKeyword(b.safety.NegativeKeywords(), tags)
+}
+
+func (b *booruSearcher) RandomSearch(ctx context.Context, query repository.BooruQuery) (*domain.Image, error) {
+	rating, ok := booruRatingValues[b.provider][query.Rating]
+	if !ok {
+		return nil, fmt.Errorf("booru: unsupported rating %q", query.Rating)
+	}
+	if b.blacklisted(query.Tags) {
+		return nil, repository.ErrorNotFound
+	}
+	posts, err := b.search(ctx, query.Tags, rating)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := map[string]bool{}
+	for r, v := range booruRatingValues[b.provider] {
+		if r <= query.Rating {
+			allowed[v] = true
+		}
+	}
+	images := []*domain.Image{}
+	for i := range posts {
+		p := &posts[i]
+		// API が rating を無視しても、ここで必ず弾く
+		if !allowed[p.Rating] || p.IsBanned || b.blacklisted(p.tags()) {
+			continue
+		}
+		image := b.image(p)
+		if image.URL == "" || b.safety.IsBlocked(image.URL, "", "") {
+			continue
+		}
+		images = append(images, image)
+	}
+	if len(images) == 0 {
+		return nil, repository.ErrorNotFound
+	}
+	return weightedPick(randomFrom(ctx, b.random), b.scores, images), nil
+}
+
+// https://danbooru.donmai.us/wiki_pages/api%3Aposts
+// https://yande.re/help/api
+func (b *booruSearcher) search(ctx context.Context, tags []string, rating string) ([]booruPost, error) {
+	q := append(append([]string{}, tags...), "rating:"+rating, "order:random")
+	params := url.Values{}
+	params.Set("tags", strings.Join(q, " "))
+	params.Set("limit", strconv.Itoa(booruPageLimit))
+	if b.login != "" && b.apiKey != "" {
+		if b.provider == booruProviderMoebooru {
+			params.Set("login", b.login)
+			params.Set("password_hash", b.apiKey)
+		} else {
+			params.Set("login", b.login)
+			params.Set("api_key", b.apiKey)
+		}
+	}
+	endpoint := "/posts.json"
+	if b.provider == booruProviderMoebooru {
+		endpoint = "/post.json"
+	}
+
+	resp, err := b.client.getOK(ctx, b.provider, b.baseURL+endpoint+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var posts []booruPost
+	if err := json.NewDecoder(resp.Body).Decode(&posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
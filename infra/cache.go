@@ -0,0 +1,93 @@
+package infra
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// 期限付きの素朴なキャッシュ。あふれたら期限切れから捨てて、それでも多ければ適当に捨てる
+type ttlCache struct {
+	name       string
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+
+	hits   int64
+	misses int64
+}
+
+func newTTLCache(name string, ttl time.Duration, maxEntries int) *ttlCache {
+	return &ttlCache{
+		name:       name,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]cacheEntry{},
+	}
+}
+
+func (c *ttlCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		if ok {
+			delete(c.entries, key)
+		}
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	return e.value, true
+}
+
+func (c *ttlCache) Set(key string, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxEntries <= len(c.entries) {
+		c.evict()
+	}
+	c.entries[key] = cacheEntry{
+		value:   value,
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+func (c *ttlCache) evict() {
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			break
+		}
+		delete(c.entries, k)
+	}
+}
+
+func (c *ttlCache) Stats() repository.CacheStats {
+	c.mu.Lock()
+	n := len(c.entries)
+	c.mu.Unlock()
+	return repository.CacheStats{
+		Name:    c.name,
+		Hits:    atomic.LoadInt64(&c.hits),
+		Misses:  atomic.LoadInt64(&c.misses),
+		Entries: n,
+	}
+}
@@ -0,0 +1,227 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	commonsAPIURL     = "https://commons.wikimedia.org/w/api.php"
+	commonsPageLimit  = 50
+	commonsMaxOffset  = 500
+	commonsThumbWidth = 1280
+	commonsUserAgent  = "iyashi-bot/1.0"
+	commonsTextLimit  = 100
+)
+
+var commonsDefaultWords = []string{
+	"cat", "kitten",
+	"dog", "puppy",
+	"rabbit",
+	"hamster",
+	"red panda",
+	"otter",
+}
+
+type commonsSearcher struct {
+	apiURL string
+	client *httpClient
+	random repository.Random
+	scores repository.ScoreStore
+	safety repository.Safety
+	totals *ttlCache
+}
+
+func newCommonsSearcher(client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety, ttl time.Duration) repository.CommonsSearcher {
+	return &commonsSearcher{
+		apiURL: commonsAPIURL,
+		client: client,
+		random: random,
+		scores: scores,
+		safety: safety,
+		totals: newTTLCache("commons_totals", ttl, 1000),
+	}
+}
+
+type commonsMetadata map[string]struct {
+	Value interface{} `json:"value"`
+}
+
+// value は文字列のことも数値のこともある
+func (m commonsMetadata) raw(key string) string {
+	v, ok := m[key]
+	if !ok || v.Value == nil {
+		return ""
+	}
+	return fmt.Sprint(v.Value)
+}
+
+// Artist などは作者ページへのリンクを含んだ HTML で来る
+func (m commonsMetadata) text(key string) string {
+	return htmlToText(m.raw(key), commonsTextLimit)
+}
+
+func (m commonsMetadata) categories() []string {
+	categories := []string{}
+	for _, c := range strings.Split(m.raw("Categories"), "|") {
+		if c != "" {
+			categories = append(categories, c)
+		}
+	}
+	return categories
+}
+
+type commonsResponse struct {
+	Continue struct {
+		GsrOffset int `json:"gsroffset"`
+	} `json:"continue"`
+	Query struct {
+		Pages map[string]struct {
+			Title     string `json:"title"`
+			ImageInfo []struct {
+				URL            string          `json:"url"`
+				ThumbURL       string          `json:"thumburl"`
+				ThumbWidth     int             `json:"thumbwidth"`
+				ThumbHeight    int             `json:"thumbheight"`
+				DescriptionURL string          `json:"descriptionurl"`
+				Mime           string          `json:"mime"`
+				ExtMetadata    commonsMetadata `json:"extmetadata"`
+			} `json:"imageinfo"`
+		} `json:"pages"`
+	} `json:"query"`
+	Error *struct {
+		Code string `json:"code"`
+		Info string `json:"info"`
+	} `json:"error"`
+}
+
+// 作者とライセンスがわからないものはクレジットを出せないので使わない
+func (r *commonsResponse) images() []*domain.Image {
+	images := []*domain.Image{}
+	for _, page := range r.Query.Pages {
+		for _, info := range page.ImageInfo {
+			if !strings.HasPrefix(info.Mime, "image/") {
+				continue
+			}
+			author := info.ExtMetadata.text("Artist")
+			license := info.ExtMetadata.text("LicenseShortName")
+			if author == "" || license == "" {
+				continue
+			}
+			u := info.ThumbURL
+			if u == "" {
+				u = info.URL
+			}
+			title := info.ExtMetadata.text("ObjectName")
+			if title == "" {
+				name := strings.TrimPrefix(page.Title, "File:")
+				title = strings.TrimSuffix(name, path.Ext(name))
+			}
+			images = append(images, &domain.Image{
+				URL:     u,
+				Source:  "Wikimedia Commons",
+				Title:   title,
+				Author:  author,
+				Link:    info.DescriptionURL,
+				License: license,
+				Width:   info.ThumbWidth,
+				Height:  info.ThumbHeight,
+				Tags:    info.ExtMetadata.categories(),
+			})
+		}
+	}
+	return images
+}
+
+func (c *commonsSearcher) RandomSearch(ctx context.Context, keywords []string) (*domain.Image, error) {
+	random := randomFrom(ctx, c.random)
+	if len(keywords) == 0 {
+		keywords = []string{commonsDefaultWords[random.Intn(len(commonsDefaultWords))]}
+	}
+	if containsNegativeKeyword(c.safety.NegativeKeywords(), keywords) {
+		return nil, repository.ErrorNotFound
+	}
+	q := strings.Join(keywords, " ")
+
+	// 検索結果の件数は返ってこないので、続きがあるとわかった範囲でずらす
+	offset := 0
+	if v, ok := c.totals.Get(q); ok {
+		if n := v.(int); 0 < n {
+			offset = random.Intn(n + 1)
+		}
+	}
+	res, err := c.search(ctx, q, offset)
+	if err != nil {
+		return nil, err
+	}
+	if known, ok := c.totals.Get(q); !ok || known.(int) < res.Continue.GsrOffset {
+		next := res.Continue.GsrOffset
+		if commonsMaxOffset < next {
+			next = commonsMaxOffset
+		}
+		c.totals.Set(q, next)
+	}
+
+	images := []*domain.Image{}
+	for _, image := range res.images() {
+		if c.safety.IsBlocked(image.URL, "", "") || containsNegativeKeyword(c.safety.NegativeKeywords(), append([]string{image.Title}, image.Tags...)) {
+			continue
+		}
+		images = append(images, image)
+	}
+	if len(images) == 0 {
+		return nil, repository.ErrorNotFound
+	}
+	return weightedPick(random, c.scores, images), nil
+}
+
+// https://www.mediawiki.org/wiki/API:Search と API:Imageinfo を generator でまとめて引く
+func (c *commonsSearcher) search(ctx context.Context, q string, offset int) (*commonsResponse, error) {
+	params := url.Values{}
+	params.Set("action", "query")
+	params.Set("format", "json")
+	params.Set("generator", "search")
+	params.Set("gsrsearch", q+" filetype:bitmap")
+	params.Set("gsrnamespace", "6")
+	params.Set("gsrlimit", strconv.Itoa(commonsPageLimit))
+	params.Set("gsroffset", strconv.Itoa(offset))
+	params.Set("prop", "imageinfo")
+	params.Set("iiprop", "url|mime|extmetadata")
+	params.Set("iiurlwidth", strconv.Itoa(commonsThumbWidth))
+	params.Set("iiextmetadatafilter", "Artist|LicenseShortName|ObjectName|Categories")
+
+	req, err := http.NewRequest(http.MethodGet, c.apiURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Wikimedia は UA の無いリクエストを弾く
+	req.Header.Set("User-Agent", commonsUserAgent)
+	resp, err := c.client.doOK(ctx, "commons", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res commonsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("commons: %s %s", res.Error.Code, res.Error.Info)
+	}
+	return &res, nil
+}
+
+func (c *commonsSearcher) CacheStats() []repository.CacheStats {
+	return []repository.CacheStats{c.totals.Stats()}
+}
@@ -0,0 +1,37 @@
+package infra
+
+import (
+	"sync"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+// 保存しておくのはその日の分だけ
+type dailyStore struct {
+	mu   sync.RWMutex
+	path string
+	pick repository.DailyPick
+}
+
+func newDailyStore(path string) (repository.DailyStore, error) {
+	d := &dailyStore{
+		path: path,
+	}
+	if err := loadJSONFile(path, &d.pick); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *dailyStore) Get() (repository.DailyPick, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.pick, d.pick.Day != "" && d.pick.Image != nil
+}
+
+func (d *dailyStore) Save(pick repository.DailyPick) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pick = pick
+	return saveJSONFile(d.path, d.pick)
+}
@@ -0,0 +1,76 @@
+package infra
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+type dictionaryEntry struct {
+	Words []string `json:"words"`
+	Tags  []string `json:"tags"`
+}
+
+type dictionary struct {
+	entries map[string]*dictionaryEntry
+}
+
+func newDictionary(path string) (repository.Dictionary, error) {
+	d := &dictionary{
+		entries: map[string]*dictionaryEntry{},
+	}
+	if path == "" {
+		return d, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*dictionaryEntry
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		for _, w := range e.Words {
+			d.entries[normalizeWord(w)] = e
+		}
+		for _, t := range e.Tags {
+			d.entries[normalizeWord(t)] = e
+		}
+	}
+	return d, nil
+}
+
+func normalizeWord(w string) string {
+	return strings.ToLower(strings.TrimSpace(w))
+}
+
+func (d *dictionary) Expand(keyword string) []string {
+	e, ok := d.entries[normalizeWord(keyword)]
+	if !ok {
+		return []string{keyword}
+	}
+	words := []string{keyword}
+	seen := map[string]bool{normalizeWord(keyword): true}
+	for _, w := range append(append([]string{}, e.Words...), e.Tags...) {
+		if seen[normalizeWord(w)] {
+			continue
+		}
+		seen[normalizeWord(w)] = true
+		words = append(words, w)
+	}
+	return words
+}
+
+func (d *dictionary) Translate(keyword string) []string {
+	e, ok := d.entries[normalizeWord(keyword)]
+	if !ok || len(e.Tags) == 0 {
+		return []string{keyword}
+	}
+	return e.Tags
+}
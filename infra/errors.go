@@ -0,0 +1,35 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+// HTTP ステータスをリポジトリのエラーに寄せる。該当しなければ nil
+func statusToError(code int) error {
+	switch {
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return repository.ErrorInvalidAPIKey
+	case code == http.StatusTooManyRequests:
+		return repository.ErrorRateLimited
+	case 500 <= code:
+		return repository.ErrorUpstreamUnavailable
+	}
+	return nil
+}
+
+// 通信エラーはリトライし尽くした結果なので上流が落ちているとみなす
+func requestError(source string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	return fmt.Errorf("%s: %s: %w", source, err, repository.ErrorUpstreamUnavailable)
+}
+
+func upstreamError(source string, err error, detail string) error {
+	return fmt.Errorf("%s: %s: %w", source, detail, err)
+}
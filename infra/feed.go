@@ -0,0 +1,257 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	feedMaxEntries = 100
+)
+
+var feedImgPattern = regexp.MustCompile(`(?i)<img[^>]+src\s*=\s*["']([^"']+)["']`)
+
+type feedSearcher struct {
+	client *httpClient
+	random repository.Random
+	scores repository.ScoreStore
+	safety repository.Safety
+	feeds  *ttlCache
+}
+
+func newFeedSearcher(client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety, ttl time.Duration) repository.FeedSearcher {
+	return &feedSearcher{
+		client: client,
+		random: random,
+		scores: scores,
+		safety: safety,
+		feeds:  newTTLCache("feeds", ttl, feedMaxEntries),
+	}
+}
+
+func (f *feedSearcher) RandomSearch(ctx context.Context, feedURL string, tags []string) (*domain.Image, error) {
+	entries, err := f.entries(ctx, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	level := repository.SafetyLevelFromContext(ctx)
+	images := []*domain.Image{}
+	for _, e := range entries {
+		if !hasAllTags(e.tags, tags) {
+			continue
+		}
+		// media:rating が adult のものは restricted のチャンネルでしか出さない
+		if e.adult && level != repository.SafetyLevelRestricted {
+			continue
+		}
+		images = append(images, safeImages(ctx, f.safety, e.tags, e.images)...)
+	}
+	if len(images) == 0 {
+		return nil, repository.ErrorNotFound
+	}
+	return weightedPick(randomFrom(ctx, f.random), f.scores, images), nil
+}
+
+func (f *feedSearcher) CacheStats() []repository.CacheStats {
+	return []repository.CacheStats{f.feeds.Stats()}
+}
+
+type feedEntry struct {
+	tags   []string
+	images []*domain.Image
+	adult  bool
+}
+
+// パースした結果をキャッシュしておく
+func (f *feedSearcher) entries(ctx context.Context, feedURL string) ([]feedEntry, error) {
+	if v, ok := f.feeds.Get(feedURL); ok {
+		return v.([]feedEntry), nil
+	}
+
+	resp, err := f.client.getOK(ctx, "feed", feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, requestError("feed", err)
+	}
+
+	var doc feedDocument
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	// 文字コードは UTF-8 以外はそのまま通す
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	entries := doc.entries(feedURL)
+	f.feeds.Set(feedURL, entries)
+	return entries, nil
+}
+
+type feedMedia struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+func (m feedMedia) isImage() bool {
+	if m.URL == "" {
+		return false
+	}
+	if m.Medium != "" {
+		return m.Medium == "image"
+	}
+	if m.Type != "" {
+		return strings.HasPrefix(m.Type, "image/")
+	}
+	return isImageURL(m.URL)
+}
+
+// RSS 2.0 と Atom のどちらでも読めるように両方の要素を持つ
+type feedDocument struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Entries []atomEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type rssItem struct {
+	Title          string      `xml:"title"`
+	Link           string      `xml:"link"`
+	Author         string      `xml:"author"`
+	Creator        string      `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Description    string      `xml:"description"`
+	ContentEncoded string      `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Categories     []string    `xml:"category"`
+	Enclosures     []feedMedia `xml:"enclosure"`
+	Media          []feedMedia `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaGroup     []feedMedia `xml:"http://search.yahoo.com/mrss/ group>content"`
+	Rating         string      `xml:"http://search.yahoo.com/mrss/ rating"`
+}
+
+type atomEntry struct {
+	Title string `xml:"http://www.w3.org/2005/Atom title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"http://www.w3.org/2005/Atom link"`
+	Author struct {
+		Name string `xml:"http://www.w3.org/2005/Atom name"`
+	} `xml:"http://www.w3.org/2005/Atom author"`
+	// type="html" ならエスケープされた文字列、type="xhtml" なら子要素で来る
+	Content struct {
+		Text  string `xml:",chardata"`
+		Inner string `xml:",innerxml"`
+	} `xml:"http://www.w3.org/2005/Atom content"`
+	Summary    string `xml:"http://www.w3.org/2005/Atom summary"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"http://www.w3.org/2005/Atom category"`
+	Media      []feedMedia `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaGroup []feedMedia `xml:"http://search.yahoo.com/mrss/ group>content"`
+	Rating     string      `xml:"http://search.yahoo.com/mrss/ rating"`
+}
+
+func (d *feedDocument) entries(feedURL string) []feedEntry {
+	entries := []feedEntry{}
+	for _, item := range d.Channel.Items {
+		author := item.Author
+		if item.Creator != "" {
+			author = item.Creator
+		}
+		media := append(append(append([]feedMedia{}, item.Enclosures...), item.Media...), item.MediaGroup...)
+		entries = append(entries, newFeedEntry(feedURL, item.Title, item.Link, author, item.Categories, media, item.Rating, item.ContentEncoded, item.Description))
+	}
+	for _, entry := range d.Entries {
+		link := ""
+		media := append(append([]feedMedia{}, entry.Media...), entry.MediaGroup...)
+		for _, l := range entry.Links {
+			switch l.Rel {
+			case "", "alternate":
+				if link == "" {
+					link = l.Href
+				}
+			case "enclosure":
+				media = append(media, feedMedia{URL: l.Href, Type: l.Type})
+			}
+		}
+		tags := make([]string, 0, len(entry.Categories))
+		for _, c := range entry.Categories {
+			tags = append(tags, c.Term)
+		}
+		entries = append(entries, newFeedEntry(feedURL, entry.Title, link, entry.Author.Name, tags, media, entry.Rating, entry.Content.Text, entry.Content.Inner, entry.Summary))
+	}
+	return entries
+}
+
+// enclosure / media:content を優先して、無ければ本文の img を拾う
+func newFeedEntry(feedURL, title, link, author string, tags []string, media []feedMedia, rating string, bodies ...string) feedEntry {
+	base, _ := url.Parse(feedURL)
+	if l, err := url.Parse(strings.TrimSpace(link)); err == nil && base != nil {
+		base = base.ResolveReference(l)
+	}
+
+	seen := map[string]bool{}
+	urls := []string{}
+	add := func(u string) {
+		u = strings.TrimSpace(html.UnescapeString(u))
+		if ref, err := url.Parse(u); err == nil && base != nil {
+			u = base.ResolveReference(ref).String()
+		}
+		if u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, m := range media {
+		if m.isImage() {
+			add(m.URL)
+		}
+	}
+	if len(urls) == 0 {
+		for _, body := range bodies {
+			for _, m := range feedImgPattern.FindAllStringSubmatch(body, -1) {
+				add(m[1])
+			}
+		}
+	}
+
+	images := make([]*domain.Image, 0, len(urls))
+	for _, u := range urls {
+		images = append(images, &domain.Image{
+			URL:    u,
+			Source: "feed",
+			Title:  strings.TrimSpace(title),
+			Author: strings.TrimSpace(author),
+			Link:   strings.TrimSpace(link),
+			Tags:   tags,
+		})
+	}
+	return feedEntry{tags: tags, images: images, adult: strings.EqualFold(strings.TrimSpace(rating), "adult")}
+}
+
+func isImageURL(u string) bool {
+	p, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mime.TypeByExtension(strings.ToLower(path.Ext(p.Path))), "image/")
+}
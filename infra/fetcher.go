@@ -0,0 +1,86 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+type imageFetcher struct {
+	client   *httpClient
+	maxBytes int64
+	auth     map[string]string
+}
+
+func newImageFetcher(client *httpClient, maxBytes int64, auth map[string]string) repository.ImageFetcher {
+	return &imageFetcher{
+		client:   client,
+		maxBytes: maxBytes,
+		auth:     auth,
+	}
+}
+
+// 画像以外や大きすぎるものは読み切る前に諦める
+func (f *imageFetcher) Fetch(ctx context.Context, imageURL string) (repository.File, error) {
+	req, err := http.NewRequest(http.MethodGet, imageURL, nil)
+	if err != nil {
+		return repository.File{}, err
+	}
+	// 認証の要るホストにだけ Authorization を付ける
+	if v, ok := f.auth[req.URL.Host]; ok {
+		req.Header.Set("Authorization", v)
+	}
+	resp, err := f.client.doOK(ctx, "fetch", req)
+	if err != nil {
+		return repository.File{}, err
+	}
+	defer resp.Body.Close()
+
+	contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(contentType, "image/") {
+		return repository.File{}, upstreamError("fetch", repository.ErrorUnsupportedImage, fmt.Sprintf("content-type=%q", resp.Header.Get("Content-Type")))
+	}
+	if 0 < f.maxBytes && f.maxBytes < resp.ContentLength {
+		return repository.File{}, upstreamError("fetch", repository.ErrorImageTooLarge, fmt.Sprintf("content-length=%d", resp.ContentLength))
+	}
+
+	var body io.Reader = resp.Body
+	if 0 < f.maxBytes {
+		body = io.LimitReader(resp.Body, f.maxBytes+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return repository.File{}, requestError("fetch", err)
+	}
+	if 0 < f.maxBytes && f.maxBytes < int64(len(data)) {
+		return repository.File{}, upstreamError("fetch", repository.ErrorImageTooLarge, fmt.Sprintf("over %d bytes", f.maxBytes))
+	}
+	return repository.File{
+		Name:        fileName(imageURL, contentType),
+		ContentType: contentType,
+		Data:        data,
+	}, nil
+}
+
+func fileName(imageURL, contentType string) string {
+	name := "image"
+	if u, err := url.Parse(imageURL); err == nil {
+		if base := path.Base(u.Path); base != "." && base != "/" {
+			name = base
+		}
+	}
+	if path.Ext(name) == "" {
+		if exts, err := mime.ExtensionsByType(contentType); err == nil && 0 < len(exts) {
+			name += exts[0]
+		}
+	}
+	return name
+}
@@ -4,22 +4,39 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
-	"net/http"
+	"io/ioutil"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mix3/iyashi-bot/domain"
 	"github.com/mix3/iyashi-bot/domain/repository"
 )
 
 type flickrSearcher struct {
-	token string
+	token      string
+	licenses   []string
+	gazetteer  *gazetteer
+	client     *httpClient
+	random     repository.Random
+	scores     repository.ScoreStore
+	safety     repository.Safety
+	pageCounts *ttlCache
+	pages      *ttlCache
 }
 
-func newFlickrSearcher(token string) repository.FlickrSearcher {
+func newFlickrSearcher(token string, licenses []string, gazetteer *gazetteer, client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety, pageCountTTL, pageTTL time.Duration) repository.FlickrSearcher {
 	return &flickrSearcher{
-		token: token,
+		token:      token,
+		licenses:   licenses,
+		gazetteer:  gazetteer,
+		client:     client,
+		random:     random,
+		scores:     scores,
+		safety:     safety,
+		pageCounts: newTTLCache("flickr_page_counts", pageCountTTL, 1000),
+		pages:      newTTLCache("flickr_pages", pageTTL, 200),
 	}
 }
 
@@ -33,120 +50,348 @@ var (
 		"パンダ",
 		"日本酒",
 	}
+
+	// https://www.flickr.com/services/api/flickr.photos.licenses.getInfo.html
+	flickrLicenseNames = map[string]string{
+		"0":  "All Rights Reserved",
+		"1":  "CC BY-NC-SA 2.0",
+		"2":  "CC BY-NC 2.0",
+		"3":  "CC BY-NC-ND 2.0",
+		"4":  "CC BY 2.0",
+		"5":  "CC BY-SA 2.0",
+		"6":  "CC BY-ND 2.0",
+		"7":  "No known copyright restrictions",
+		"8":  "United States Government Work",
+		"9":  "CC0 1.0",
+		"10": "Public Domain Mark 1.0",
+		"11": "CC BY 4.0",
+		"12": "CC BY-SA 4.0",
+		"13": "CC BY-ND 4.0",
+		"14": "CC BY-NC 4.0",
+		"15": "CC BY-NC-SA 4.0",
+		"16": "CC BY-NC-ND 4.0",
+	}
 )
 
-func (f *flickrSearcher) search(ctx context.Context, keywords []string, page int) (*flickrSearchResponse, error) {
-	const baseUrl = "https://api.flickr.com/services/rest/"
+func (f *flickrSearcher) searchParams(ctx context.Context, query repository.FlickrQuery, geo *place) url.Values {
+	// 除外をクエリに書けるのは text と、tag_mode=all の tags だけ。
+	// それ以外は RandomImage でタイトルとタグを見て弾く
+	args := append([]string{}, query.Keywords...)
+	tags := append([]string{}, query.Tags...)
+	for _, n := range f.safety.NegativeKeywords() {
+		args = append(args, "-"+n)
+		if query.TagMode != "any" {
+			tags = append(tags, "-"+n)
+		}
+	}
 
-	u, err := url.Parse(baseUrl)
-	if err != nil {
+	params := url.Values{}
+	params.Set("method", "flickr.photos.search")
+	if 0 < len(query.Keywords) {
+		params.Set("text", strings.Join(args, " "))
+	}
+	if 0 < len(query.Tags) {
+		params.Set("tags", strings.Join(tags, ","))
+		if query.TagMode != "" {
+			params.Set("tag_mode", query.TagMode)
+		}
+	}
+	if query.Sort != "" {
+		params.Set("sort", query.Sort)
+	}
+	if query.GroupID != "" {
+		params.Set("group_id", query.GroupID)
+	}
+	if query.UserID != "" {
+		params.Set("user_id", query.UserID)
+	}
+	if geo != nil {
+		geo.apply(params, query.RadiusKm)
+	}
+	// 1=safe 2=moderate 3=restricted で SafetyLevel と同じ並び
+	params.Set("safe_search", strconv.Itoa(int(repository.SafetyLevelFromContext(ctx))))
+	params.Set("media", "photo")
+	params.Set("extras", "owner_name,license,tags,url_m,url_z,url_b,url_h")
+	if 0 < len(f.licenses) {
+		params.Set("license", strings.Join(f.licenses, ","))
+	}
+	if query.Orientation != "" {
+		params.Set("orientation", query.Orientation)
+	}
+	return params
+}
+
+// 同じ条件のページは一定時間使い回す。ついでに総ページ数も覚えておく
+func (f *flickrSearcher) search(ctx context.Context, params url.Values, page int) (*flickrSearchResponse, error) {
+	key := params.Encode()
+	pageKey := fmt.Sprintf("%s#%d", key, page)
+	if v, ok := f.pages.Get(pageKey); ok {
+		return v.(*flickrSearchResponse), nil
+	}
+
+	p := url.Values{}
+	for k, v := range params {
+		p[k] = v
+	}
+	p.Set("page", strconv.Itoa(page))
+
+	var res *flickrSearchResponse
+	if err := f.call(ctx, p, &res); err != nil {
 		return nil, err
 	}
+	f.pages.Set(pageKey, res)
+	f.pageCounts.Set(key, res.Photos.Pages)
+	return res, nil
+}
+
+type flickrStat struct {
+	Stat    string `json:"stat"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// flickr は失敗しても 200 で stat=fail を返してくるので先に見る
+func (f *flickrSearcher) call(ctx context.Context, params url.Values, v interface{}) error {
+	const baseUrl = "https://api.flickr.com/services/rest/"
 
-	args := keywords
-	if len(args) == 0 {
-		args = append(args, flickrDefaultWords[rand.Intn(len(flickrDefaultWords))])
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return err
 	}
-	args = append(args, "-hentai", "-porn", "-sexy", "-fuck")
 
-	params := url.Values{}
 	params.Set("api_key", f.token)
 	params.Set("format", "json")
 	params.Set("nojsoncallback", "1")
-	params.Set("method", "flickr.photos.search")
-	params.Set("text", strings.Join(args, " "))
-	params.Set("safe_mode", "1")
-	params.Set("media", "photo")
-	if 0 < page {
-		params.Set("page", strconv.Itoa(page))
-	}
-
 	u.RawQuery = params.Encode()
 
-	resp, err := http.Get(u.String())
+	resp, err := f.client.getOK(ctx, "flickr", u.String())
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	var res *flickrSearchResponse
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return nil, err
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return requestError("flickr", err)
 	}
-	return res, nil
+	var stat flickrStat
+	if err := json.Unmarshal(body, &stat); err != nil {
+		return err
+	}
+	if stat.Stat == "fail" {
+		return stat.err()
+	}
+	return json.Unmarshal(body, v)
+}
+
+// https://www.flickr.com/services/api/flickr.photos.search.html
+func (s flickrStat) err() error {
+	detail := fmt.Sprintf("code=%d %s", s.Code, s.Message)
+	switch s.Code {
+	case 100:
+		return upstreamError("flickr", repository.ErrorInvalidAPIKey, detail)
+	case 10, 105:
+		return upstreamError("flickr", repository.ErrorUpstreamUnavailable, detail)
+	}
+	return fmt.Errorf("flickr: %s", detail)
+}
+
+// width_* / height_* は数値で来たり文字列で来たりする
+type flickrInt int
+
+func (i *flickrInt) UnmarshalJSON(b []byte) error {
+	v := strings.Trim(string(b), `"`)
+	if v == "" || v == "null" {
+		*i = 0
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*i = flickrInt(n)
+	return nil
+}
+
+type flickrPhoto struct {
+	Id        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Secret    string    `json:"secret"`
+	Server    string    `json:"server"`
+	Farm      int       `json:"farm"`
+	Title     string    `json:"title"`
+	Ispublic  int       `json:"ispublic"`
+	Isfriend  int       `json:"isfriend"`
+	Isfamily  int       `json:"isfamily"`
+	OwnerName string    `json:"ownername"`
+	License   string    `json:"license"`
+	Tags      string    `json:"tags"`
+	UrlM      string    `json:"url_m"`
+	WidthM    flickrInt `json:"width_m"`
+	HeightM   flickrInt `json:"height_m"`
+	UrlZ      string    `json:"url_z"`
+	WidthZ    flickrInt `json:"width_z"`
+	HeightZ   flickrInt `json:"height_z"`
+	UrlB      string    `json:"url_b"`
+	WidthB    flickrInt `json:"width_b"`
+	HeightB   flickrInt `json:"height_b"`
+	UrlH      string    `json:"url_h"`
+	WidthH    flickrInt `json:"width_h"`
+	HeightH   flickrInt `json:"height_h"`
+}
+
+// 指定のサイズが無ければひとつ小さいサイズに落とす
+func (p *flickrPhoto) sized(size string) (string, int, int) {
+	candidates := []struct {
+		size   string
+		url    string
+		width  flickrInt
+		height flickrInt
+	}{
+		{repository.FlickrSizeHuge, p.UrlH, p.WidthH, p.HeightH},
+		{repository.FlickrSizeLarge, p.UrlB, p.WidthB, p.HeightB},
+		{repository.FlickrSizeMedium, p.UrlZ, p.WidthZ, p.HeightZ},
+		{"", p.UrlM, p.WidthM, p.HeightM},
+	}
+	found := false
+	for _, c := range candidates {
+		if c.size == size {
+			found = true
+		}
+		if found && c.url != "" {
+			return c.url, int(c.width), int(c.height)
+		}
+	}
+	return fmt.Sprintf(
+		`https://farm%d.staticflickr.com/%s/%s_%s.jpg`,
+		p.Farm,
+		p.Server,
+		p.Id,
+		p.Secret,
+	), 0, 0
 }
 
 type flickrSearchResponse struct {
 	Photos struct {
-		Page    int `json:"page"`
-		Pages   int `json:"pages"`
-		PerPage int `json:"perpage"`
-		Total   int `json:"total"`
-		Photo   []struct {
-			Id       string `json:"id"`
-			Owner    string `json:"owner"`
-			Secret   string `json:"secret"`
-			Server   string `json:"server"`
-			Farm     int    `json:"farm"`
-			Title    string `json:"title"`
-			Ispublic int    `json:"ispublic"`
-			Isfriend int    `json:"isfriend"`
-			Isfamily int    `json:"isfamily"`
-		} `json:"photo"`
+		Page    int           `json:"page"`
+		Pages   int           `json:"pages"`
+		PerPage int           `json:"perpage"`
+		Total   int           `json:"total"`
+		Photo   []flickrPhoto `json:"photo"`
 	} `json:"photos"`
 }
 
-func (f *flickrSearchResponse) RandomImageURL() string {
-	if n := len(f.Photos.Photo); 0 < n {
-		photo := f.Photos.Photo[rand.Intn(n)]
-		return fmt.Sprintf(
-			`https://farm%d.staticflickr.com/%s/%s_%s.jpg`,
-			photo.Farm,
-			photo.Server,
-			photo.Id,
-			photo.Secret,
-		)
-	}
-	return ""
+func (f *flickrSearchResponse) RandomImage(query repository.FlickrQuery, random repository.Random, scores repository.ScoreStore, safety repository.Safety) *domain.Image {
+	images := make([]*domain.Image, 0, len(f.Photos.Photo))
+	for _, photo := range f.Photos.Photo {
+		u, width, height := photo.sized(query.Size)
+		if safety.IsBlocked(u, photo.Id, photo.Owner) {
+			continue
+		}
+		if !matchDimension(query, width, height) {
+			continue
+		}
+		if containsNegativeKeyword(safety.NegativeKeywords(), append([]string{photo.Title}, strings.Fields(photo.Tags)...)) {
+			continue
+		}
+		author := photo.OwnerName
+		if author == "" {
+			author = photo.Owner
+		}
+		images = append(images, &domain.Image{
+			URL:     u,
+			Source:  "flickr",
+			Title:   photo.Title,
+			Author:  author,
+			Link:    fmt.Sprintf("https://www.flickr.com/photos/%s/%s", photo.Owner, photo.Id),
+			License: flickrLicenseNames[photo.License],
+			Width:   width,
+			Height:  height,
+			PhotoID: photo.Id,
+			Owner:   photo.Owner,
+		})
+	}
+	if 0 < len(images) {
+		return weightedPick(random, scores, images)
+	}
+	return nil
+}
+
+// サイズがわからない画像は条件が指定されていたら弾く
+func matchDimension(query repository.FlickrQuery, width, height int) bool {
+	if query.MinWidth == 0 && query.MinHeight == 0 && query.Orientation == "" {
+		return true
+	}
+	if width == 0 || height == 0 {
+		return false
+	}
+	if width < query.MinWidth || height < query.MinHeight {
+		return false
+	}
+	ratio := float64(width) / float64(height)
+	switch query.Orientation {
+	case repository.OrientationLandscape:
+		return 1.1 < ratio
+	case repository.OrientationPortrait:
+		return ratio < 0.9
+	case repository.OrientationSquare:
+		return 0.9 <= ratio && ratio <= 1.1
+	}
+	return true
+}
+
+func (f *flickrSearcher) CacheStats() []repository.CacheStats {
+	return []repository.CacheStats{
+		f.pageCounts.Stats(),
+		f.pages.Stats(),
+	}
 }
 
-func (f *flickrSearcher) RandomSearch(ctx context.Context, keywords []string) (repository.FlickrRandomSearchResponse, error) {
+func (f *flickrSearcher) RandomSearch(ctx context.Context, query repository.FlickrQuery) (*domain.Image, error) {
 	const limitPageNum = 40
 
-	res, err := f.search(ctx, keywords, 0)
-	if err != nil {
-		return nil, err
+	random := randomFrom(ctx, f.random)
+	defaultWord := ""
+	if len(query.Keywords) == 0 && !query.Scoped() {
+		defaultWord = weightedWord(random, f.scores, flickrDefaultWords)
+		query.Keywords = []string{defaultWord}
+	}
+	var geo *place
+	if query.Place != "" {
+		p, err := f.resolvePlace(ctx, query.Place)
+		if err != nil {
+			return nil, err
+		}
+		geo = &p
 	}
+	params := f.searchParams(ctx, query, geo)
 
-	pageRange := res.Photos.Pages
+	var pageRange int
+	if v, ok := f.pageCounts.Get(params.Encode()); ok {
+		pageRange = v.(int)
+	} else {
+		res, err := f.search(ctx, params, 1)
+		if err != nil {
+			return nil, err
+		}
+		pageRange = res.Photos.Pages
+	}
 	if limitPageNum < pageRange {
 		pageRange = limitPageNum
 	}
+	if pageRange == 0 {
+		return nil, repository.ErrorNotFound
+	}
 
-	var imageURL string
 	for i := 0; i < 3; i++ {
-		res, err = f.search(ctx, keywords, rand.Intn(pageRange+1))
+		res, err := f.search(ctx, params, 1+random.Intn(pageRange))
 		if err != nil {
 			return nil, err
 		}
-		imageURL = res.RandomImageURL()
-		if imageURL != "" {
-			break
+		if image := res.RandomImage(query, random, f.scores, f.safety); image != nil {
+			image.Query = defaultWord
+			return image, nil
 		}
 	}
-	if imageURL == "" {
-		return nil, repository.ErrorNotFound
-	}
-
-	return &flickrRandomSearchResponse{
-		imageURL: imageURL,
-	}, nil
-}
-
-type flickrRandomSearchResponse struct {
-	imageURL string
-}
-
-func (f *flickrRandomSearchResponse) ImageURL() string {
-	return f.imageURL
+	return nil, repository.ErrorNotFound
 }
@@ -0,0 +1,220 @@
+package infra
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+// 通報した写真は、DM で返したものでもサイズ違いの URL でも二度と出ない
+func TestReportedFlickrPhotoIsNotReturned(t *testing.T) {
+	dir := t.TempDir()
+	random, err := newRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores, err := newScoreStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	safety, err := newSafety("safe", nil, nil, filepath.Join(dir, "blocklist.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	postLog, err := newPostLog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := &flickrSearchResponse{}
+	res.Photos.Photo = []flickrPhoto{
+		{Id: "1", Owner: "alice", UrlM: "https://live.staticflickr.com/1_m.jpg", UrlB: "https://live.staticflickr.com/1_b.jpg"},
+		{Id: "2", Owner: "bob", UrlM: "https://live.staticflickr.com/2_m.jpg", UrlB: "https://live.staticflickr.com/2_b.jpg"},
+	}
+	image := &flickrSearchResponse{}
+	image.Photos.Photo = res.Photos.Photo[:1]
+	reported := image.RandomImage(repository.FlickrQuery{}, random, scores, safety)
+
+	// 癒しの画像は DM で返すので、投稿先は DM のチャンネルになる
+	if err := postLog.Record(repository.Post{
+		Channel:        "D1",
+		TS:             "1.0",
+		RequestChannel: "C1",
+		User:           "U1",
+		ImageURL:       reported.URL,
+		PhotoID:        reported.PhotoID,
+		Owner:          reported.Owner,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	post, ok := postLog.LastRequestedImage("C1")
+	if !ok || post.PhotoID != "1" {
+		t.Fatalf("LastRequestedImage(C1) = %+v, %v", post, ok)
+	}
+	if err := safety.BlockURL(post.ImageURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := safety.BlockPhotoID(post.PhotoID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []string{"", repository.FlickrSizeLarge} {
+		for i := 0; i < 20; i++ {
+			got := res.RandomImage(repository.FlickrQuery{Size: size}, random, scores, safety)
+			if got == nil || got.PhotoID == "1" {
+				t.Fatalf("size=%q got %+v, reported photo was returned", size, got)
+			}
+		}
+	}
+
+	// ブロックリストは保存されていて、読み直しても効く
+	reloaded, err := newSafety("safe", nil, nil, filepath.Join(dir, "blocklist.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.IsBlocked("https://live.staticflickr.com/1_b.jpg", "1", "alice") {
+		t.Error("photo ID was not persisted")
+	}
+}
+
+// グループ指定や tag_mode=any ではクエリで除外できないので、タイトルとタグで弾く
+func TestFlickrNegativeKeywords(t *testing.T) {
+	random, err := newRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores, err := newScoreStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	safety, err := newSafety("moderate", nil, []string{"gore"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &flickrSearcher{safety: safety}
+
+	params := f.searchParams(context.Background(), repository.FlickrQuery{Tags: []string{"cat", "dog"}, TagMode: "any"}, nil)
+	if tags := params.Get("tags"); tags != "cat,dog" {
+		t.Errorf("tag_mode=any tags = %q, negative tags must not be OR'd in", tags)
+	}
+	params = f.searchParams(context.Background(), repository.FlickrQuery{Tags: []string{"cat"}}, nil)
+	if tags := params.Get("tags"); tags != "cat,-gore" {
+		t.Errorf("tags = %q", tags)
+	}
+	if extras := params.Get("extras"); !strings.Contains(extras, "tags") {
+		t.Errorf("extras = %q, tags are needed for filtering", extras)
+	}
+
+	res := &flickrSearchResponse{}
+	res.Photos.Photo = []flickrPhoto{
+		{Id: "1", Title: "GORE cat", UrlM: "https://live.staticflickr.com/1_m.jpg"},
+		{Id: "2", Title: "cat", Tags: "cat gore", UrlM: "https://live.staticflickr.com/2_m.jpg"},
+		{Id: "3", Title: "cat", Tags: "cat cute", UrlM: "https://live.staticflickr.com/3_m.jpg"},
+	}
+	for i := 0; i < 20; i++ {
+		got := res.RandomImage(repository.FlickrQuery{GroupID: "g1"}, random, scores, safety)
+		if got == nil || got.PhotoID != "3" {
+			t.Fatalf("got %+v, want only photo 3", got)
+		}
+	}
+}
+
+// api.flickr.com 宛てのリクエストをテスト用のサーバーに向ける
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// flickr の API をテスト用のサーバーに向けた flickrSearcher を作る
+func newTestFlickrSearcher(t *testing.T, ts *httptest.Server) *flickrSearcher {
+	t.Helper()
+	target, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random, err := newRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores, err := newScoreStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	safety, err := newSafety("moderate", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gazetteer, err := newGazetteer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newHTTPClient(random)
+	client.client = &http.Client{Transport: rewriteTransport{target: target}}
+	return newFlickrSearcher("test-key", nil, gazetteer, client, random, scores, safety, time.Minute, time.Minute).(*flickrSearcher)
+}
+
+const flickrOnePhoto = `{"stat": "ok", "photos": {"page": 1, "pages": 1, "perpage": 100, "total": 1,
+  "photo": [{"id": "1", "owner": "alice", "title": "tama", "url_m": "https://live.staticflickr.com/1_m.jpg"}]}}`
+
+// 指定が無くて選んだワードは、評価を付けられるように画像に残す
+func TestFlickrRandomSearchReportsDefaultWord(t *testing.T) {
+	texts := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		texts = append(texts, r.URL.Query().Get("text"))
+		w.Write([]byte(flickrOnePhoto))
+	}))
+	defer ts.Close()
+	f := newTestFlickrSearcher(t, ts)
+
+	image, err := f.RandomSearch(context.Background(), repository.FlickrQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Query == "" || len(texts) == 0 || texts[0] != image.Query {
+		t.Errorf("image.Query = %q, searched %v", image.Query, texts)
+	}
+
+	image, err = f.RandomSearch(context.Background(), repository.FlickrQuery{Keywords: []string{"tama"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Query != "" {
+		t.Errorf("image.Query = %q, want empty when keywords are given", image.Query)
+	}
+}
+
+// 地名だけだと直近 12 時間の写真しか返ってこないので、いつものワードも付ける
+func TestFlickrRandomSearchGeoOnlyUsesDefaultWord(t *testing.T) {
+	var got url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		w.Write([]byte(flickrOnePhoto))
+	}))
+	defer ts.Close()
+	f := newTestFlickrSearcher(t, ts)
+	f.gazetteer.store("京都", place{Lat: 35.0, Lon: 135.7, RadiusKm: 10})
+
+	image, err := f.RandomSearch(context.Background(), repository.FlickrQuery{Place: "京都"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("lat") == "" {
+		t.Errorf("geo was not applied: %v", got)
+	}
+	if text := got.Get("text"); text == "" || text != image.Query {
+		t.Errorf("text = %q, image.Query = %q", text, image.Query)
+	}
+}
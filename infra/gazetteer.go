@@ -0,0 +1,104 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	gazetteerDefaultRadiusKm = 5
+)
+
+type place struct {
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	RadiusKm float64 `json:"radius_km"`
+	PlaceID  string  `json:"place_id"`
+}
+
+// 地名 -> 座標の対応表。ファイルに無い地名は flickr.places.find で引いて覚えておく
+type gazetteer struct {
+	mu     sync.RWMutex
+	places map[string]place
+}
+
+func newGazetteer(path string) (*gazetteer, error) {
+	g := &gazetteer{
+		places: map[string]place{},
+	}
+	if err := loadJSONFile(path, &g.places); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *gazetteer) lookup(name string) (place, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	p, ok := g.places[name]
+	return p, ok
+}
+
+func (g *gazetteer) store(name string, p place) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.places[name] = p
+}
+
+type flickrPlacesFindResponse struct {
+	Places struct {
+		Place []struct {
+			PlaceID   string `json:"place_id"`
+			Latitude  string `json:"latitude"`
+			Longitude string `json:"longitude"`
+			Name      string `json:"_content"`
+		} `json:"place"`
+	} `json:"places"`
+}
+
+func (f *flickrSearcher) resolvePlace(ctx context.Context, name string) (place, error) {
+	if p, ok := f.gazetteer.lookup(name); ok {
+		return p, nil
+	}
+
+	params := url.Values{}
+	params.Set("method", "flickr.places.find")
+	params.Set("query", name)
+	var res flickrPlacesFindResponse
+	if err := f.call(ctx, params, &res); err != nil {
+		return place{}, err
+	}
+	if len(res.Places.Place) == 0 {
+		return place{}, fmt.Errorf("flickr: place=%s: %w", name, repository.ErrorNotFound)
+	}
+	found := res.Places.Place[0]
+	p := place{PlaceID: found.PlaceID}
+	p.Lat, _ = strconv.ParseFloat(found.Latitude, 64)
+	p.Lon, _ = strconv.ParseFloat(found.Longitude, 64)
+	f.gazetteer.store(name, p)
+	return p, nil
+}
+
+// 座標がわかっていれば半径で、無ければ place_id で絞る
+func (p place) apply(params url.Values, radiusKm float64) {
+	if p.Lat == 0 && p.Lon == 0 && p.PlaceID != "" {
+		params.Set("place_id", p.PlaceID)
+		return
+	}
+	if radiusKm <= 0 {
+		radiusKm = p.RadiusKm
+	}
+	if radiusKm <= 0 {
+		radiusKm = gazetteerDefaultRadiusKm
+	}
+	params.Set("lat", strconv.FormatFloat(p.Lat, 'f', -1, 64))
+	params.Set("lon", strconv.FormatFloat(p.Lon, 'f', -1, 64))
+	params.Set("radius", strconv.FormatFloat(radiusKm, 'f', -1, 64))
+	params.Set("radius_units", "km")
+	params.Set("has_geo", "1")
+}
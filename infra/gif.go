@@ -0,0 +1,287 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	gifProviderGiphy = "giphy"
+	gifProviderTenor = "tenor"
+
+	gifPageLimit = 25
+	// giphy は offset が大きいと何も返さなくなる
+	gifMaxOffset = 500
+)
+
+var (
+	gifDefaultWords = []string{
+		"cat", "kitten",
+		"dog", "puppy",
+		"bunny",
+		"hamster",
+		"panda",
+	}
+
+	gifDefaultBaseURLs = map[string]string{
+		gifProviderGiphy: "https://api.giphy.com",
+		gifProviderTenor: "https://tenor.googleapis.com",
+	}
+
+	// rating の厳しい順。指定より緩いものは返ってきても捨てる
+	gifRatings = []string{"g", "pg", "pg-13", "r"}
+
+	// https://developers.google.com/tenor/guides/content-filtering
+	tenorContentFilters = map[string]string{
+		"g":     "high",
+		"pg":    "medium",
+		"pg-13": "low",
+		"r":     "off",
+	}
+)
+
+// Giphy と、Giphy 互換の口を持つ Tenor (v2) のどちらかを叩く
+type gifSearcher struct {
+	provider string
+	baseURL  string
+	apiKey   string
+	rating   string
+	client   *httpClient
+	random   repository.Random
+	scores   repository.ScoreStore
+	safety   repository.Safety
+	totals   *ttlCache
+}
+
+func newGifSearcher(provider, baseURL, apiKey, rating string, client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety, ttl time.Duration) repository.GifSearcher {
+	if provider == "" {
+		provider = gifProviderGiphy
+	}
+	if baseURL == "" {
+		baseURL = gifDefaultBaseURLs[provider]
+	}
+	return &gifSearcher{
+		provider: provider,
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		apiKey:   apiKey,
+		rating:   rating,
+		client:   client,
+		random:   random,
+		scores:   scores,
+		safety:   safety,
+		totals:   newTTLCache("gif_totals", ttl, 1000),
+	}
+}
+
+type gifResult struct {
+	images []*domain.Image
+	total  int
+}
+
+func (g *gifSearcher) RandomSearch(ctx context.Context, keywords []string) (*domain.Image, error) {
+	random := randomFrom(ctx, g.random)
+	if len(keywords) == 0 {
+		keywords = []string{gifDefaultWords[random.Intn(len(gifDefaultWords))]}
+	}
+	if containsNegativeKeyword(g.safety.NegativeKeywords(), keywords) {
+		return nil, repository.ErrorNotFound
+	}
+	q := strings.Join(keywords, " ")
+	rating := g.ratingFor(ctx)
+	key := q + "#" + rating
+
+	// 総数がわかっていればその範囲でずらして取る
+	offset := 0
+	if v, ok := g.totals.Get(key); ok {
+		if total := v.(int); gifPageLimit < total {
+			n := total - gifPageLimit
+			if gifMaxOffset < n {
+				n = gifMaxOffset
+			}
+			offset = random.Intn(n + 1)
+		}
+	}
+
+	res, err := g.search(ctx, q, rating, offset)
+	if err != nil {
+		return nil, err
+	}
+	g.totals.Set(key, res.total)
+
+	images := make([]*domain.Image, 0, len(res.images))
+	for _, image := range res.images {
+		if g.safety.IsBlocked(image.URL, "", "") {
+			continue
+		}
+		images = append(images, image)
+	}
+	if len(images) == 0 {
+		return nil, repository.ErrorNotFound
+	}
+	return weightedPick(random, g.scores, images), nil
+}
+
+func (g *gifSearcher) search(ctx context.Context, q, rating string, offset int) (*gifResult, error) {
+	if g.provider == gifProviderTenor {
+		return g.searchTenor(ctx, q, rating)
+	}
+	return g.searchGiphy(ctx, q, rating, offset)
+}
+
+func (g *gifSearcher) get(ctx context.Context, u string, v interface{}) error {
+	resp, err := g.client.getOK(ctx, g.provider, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// safe のチャンネルでは設定に関わらず g だけにする
+func (g *gifSearcher) ratingFor(ctx context.Context) string {
+	if repository.SafetyLevelFromContext(ctx) == repository.SafetyLevelSafe {
+		return gifRatings[0]
+	}
+	return g.rating
+}
+
+// 指定の rating より緩いか、rating が不明なものは弾く
+func allowedGifRating(rating, max string) bool {
+	for _, r := range gifRatings {
+		if r == strings.ToLower(rating) {
+			return true
+		}
+		if r == max {
+			return false
+		}
+	}
+	return false
+}
+
+// mp4 は Slack で展開されないので GIF の URL だけ使う
+type giphyImage struct {
+	URL    string `json:"url"`
+	Width  string `json:"width"`
+	Height string `json:"height"`
+}
+
+type giphySearchResponse struct {
+	Data []struct {
+		ID       string `json:"id"`
+		Title    string `json:"title"`
+		URL      string `json:"url"`
+		Username string `json:"username"`
+		Rating   string `json:"rating"`
+		Images   struct {
+			Original giphyImage `json:"original"`
+			// original は数十 MB になることがあるので普段はこちらを使う
+			Downsized giphyImage `json:"downsized_large"`
+		} `json:"images"`
+	} `json:"data"`
+	Pagination struct {
+		TotalCount int `json:"total_count"`
+	} `json:"pagination"`
+}
+
+// https://developers.giphy.com/docs/api/endpoint#search
+func (g *gifSearcher) searchGiphy(ctx context.Context, q, rating string, offset int) (*gifResult, error) {
+	params := url.Values{}
+	params.Set("api_key", g.apiKey)
+	params.Set("q", q)
+	params.Set("limit", strconv.Itoa(gifPageLimit))
+	params.Set("offset", strconv.Itoa(offset))
+	params.Set("rating", rating)
+
+	var res giphySearchResponse
+	if err := g.get(ctx, fmt.Sprintf("%s/v1/gifs/search?%s", g.baseURL, params.Encode()), &res); err != nil {
+		return nil, err
+	}
+	images := []*domain.Image{}
+	for _, d := range res.Data {
+		if !allowedGifRating(d.Rating, rating) {
+			continue
+		}
+		img := d.Images.Downsized
+		if img.URL == "" {
+			img = d.Images.Original
+		}
+		if img.URL == "" {
+			continue
+		}
+		width, _ := strconv.Atoi(img.Width)
+		height, _ := strconv.Atoi(img.Height)
+		images = append(images, &domain.Image{
+			URL:    img.URL,
+			Source: "giphy",
+			Title:  d.Title,
+			Author: d.Username,
+			Link:   d.URL,
+			Width:  width,
+			Height: height,
+		})
+	}
+	return &gifResult{images: images, total: res.Pagination.TotalCount}, nil
+}
+
+type tenorSearchResponse struct {
+	Results []struct {
+		ID                 string `json:"id"`
+		ContentDescription string `json:"content_description"`
+		ItemURL            string `json:"itemurl"`
+		MediaFormats       map[string]struct {
+			URL  string `json:"url"`
+			Dims []int  `json:"dims"`
+		} `json:"media_formats"`
+	} `json:"results"`
+}
+
+// https://developers.google.com/tenor/guides/endpoints#search
+// pos はトークンなので先頭ページだけから選ぶ
+func (g *gifSearcher) searchTenor(ctx context.Context, q, rating string) (*gifResult, error) {
+	params := url.Values{}
+	params.Set("key", g.apiKey)
+	params.Set("q", q)
+	params.Set("limit", strconv.Itoa(gifPageLimit))
+	params.Set("contentfilter", tenorContentFilters[rating])
+	params.Set("media_filter", "gif,mediumgif")
+
+	var res tenorSearchResponse
+	if err := g.get(ctx, fmt.Sprintf("%s/v2/search?%s", g.baseURL, params.Encode()), &res); err != nil {
+		return nil, err
+	}
+	images := []*domain.Image{}
+	for _, r := range res.Results {
+		f, ok := r.MediaFormats["mediumgif"]
+		if !ok || f.URL == "" {
+			f = r.MediaFormats["gif"]
+		}
+		if f.URL == "" {
+			continue
+		}
+		width, height := 0, 0
+		if len(f.Dims) == 2 {
+			width, height = f.Dims[0], f.Dims[1]
+		}
+		images = append(images, &domain.Image{
+			URL:    f.URL,
+			Source: "tenor",
+			Title:  r.ContentDescription,
+			Link:   r.ItemURL,
+			Width:  width,
+			Height: height,
+		})
+	}
+	return &gifResult{images: images, total: len(images)}, nil
+}
+
+func (g *gifSearcher) CacheStats() []repository.CacheStats {
+	return []repository.CacheStats{g.totals.Stats()}
+}
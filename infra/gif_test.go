@@ -0,0 +1,200 @@
+package infra
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+func newTestGifSearcher(t *testing.T, provider, baseURL, rating string) *gifSearcher {
+	t.Helper()
+	random, err := newRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores, err := newScoreStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	safety, err := newSafety("moderate", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newGifSearcher(provider, baseURL, "test-key", rating, newHTTPClient(random), random, scores, safety, time.Minute).(*gifSearcher)
+}
+
+func imageURLs(images []*domain.Image) []string {
+	urls := make([]string, 0, len(images))
+	for _, image := range images {
+		urls = append(urls, image.URL)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAllowedGifRating(t *testing.T) {
+	tests := []struct {
+		rating string
+		max    string
+		want   bool
+	}{
+		{"g", "g", true},
+		{"pg", "g", false},
+		{"G", "g", true},
+		{"g", "pg-13", true},
+		{"pg-13", "pg-13", true},
+		{"r", "pg-13", false},
+		{"r", "r", true},
+		{"", "r", false},
+		{"unknown", "r", false},
+	}
+	for _, tt := range tests {
+		if got := allowedGifRating(tt.rating, tt.max); got != tt.want {
+			t.Errorf("allowedGifRating(%q, %q) = %v, want %v", tt.rating, tt.max, got, tt.want)
+		}
+	}
+}
+
+const giphyResponse = `{
+  "data": [
+    {"id": "1", "title": "cat g", "url": "https://giphy.com/gifs/1", "username": "alice", "rating": "g",
+     "images": {"original": {"url": "https://media.giphy.com/1/original.gif", "width": "480", "height": "270"},
+                "downsized_large": {"url": "https://media.giphy.com/1/downsized.gif", "width": "320", "height": "180"}}},
+    {"id": "2", "title": "cat pg", "url": "https://giphy.com/gifs/2", "rating": "pg",
+     "images": {"original": {"url": "https://media.giphy.com/2/original.gif", "width": "480", "height": "270"}}},
+    {"id": "3", "title": "cat r", "url": "https://giphy.com/gifs/3", "rating": "r",
+     "images": {"original": {"url": "https://media.giphy.com/3/original.gif", "width": "480", "height": "270"}}},
+    {"id": "4", "title": "no image", "url": "https://giphy.com/gifs/4", "rating": "g", "images": {}}
+  ],
+  "pagination": {"total_count": 120}
+}`
+
+func TestGifSearcherGiphy(t *testing.T) {
+	var got url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/gifs/search" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		got = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(giphyResponse))
+	}))
+	defer ts.Close()
+
+	g := newTestGifSearcher(t, gifProviderGiphy, ts.URL, "pg")
+	res, err := g.search(context.Background(), "cat", "pg", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"api_key": "test-key", "q": "cat", "rating": "pg", "offset": "25"} {
+		if v := got.Get(k); v != want {
+			t.Errorf("query %s = %q, want %q", k, v, want)
+		}
+	}
+	want := []string{"https://media.giphy.com/1/downsized.gif", "https://media.giphy.com/2/original.gif"}
+	if urls := imageURLs(res.images); !equalStrings(urls, want) {
+		t.Errorf("images = %v, want %v", urls, want)
+	}
+	if res.total != 120 {
+		t.Errorf("total = %d, want 120", res.total)
+	}
+	if image := res.images[0]; image.Author != "alice" || image.Link != "https://giphy.com/gifs/1" || image.Width != 320 {
+		t.Errorf("unexpected image %+v", image)
+	}
+}
+
+// safe のチャンネルでは設定が pg でも g で検索して g だけ返す
+func TestGifSearcherSafeChannel(t *testing.T) {
+	var rating string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rating = r.URL.Query().Get("rating")
+		w.Write([]byte(giphyResponse))
+	}))
+	defer ts.Close()
+
+	g := newTestGifSearcher(t, gifProviderGiphy, ts.URL, "pg")
+	ctx := repository.WithSafetyLevel(context.Background(), repository.SafetyLevelSafe)
+	for i := 0; i < 10; i++ {
+		image, err := g.RandomSearch(ctx, []string{"cat"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image.URL != "https://media.giphy.com/1/downsized.gif" {
+			t.Errorf("got %s, want only the g rated gif", image.URL)
+		}
+	}
+	if rating != "g" {
+		t.Errorf("rating = %q, want g", rating)
+	}
+}
+
+func TestGifSearcherTenor(t *testing.T) {
+	var got url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/search" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		got = r.URL.Query()
+		w.Write([]byte(`{
+  "results": [
+    {"id": "1", "content_description": "cat", "itemurl": "https://tenor.com/view/1",
+     "media_formats": {"mediumgif": {"url": "https://media.tenor.com/1/medium.gif", "dims": [220, 140]},
+                       "gif": {"url": "https://media.tenor.com/1/full.gif", "dims": [498, 318]}}},
+    {"id": "2", "content_description": "dog", "itemurl": "https://tenor.com/view/2",
+     "media_formats": {"gif": {"url": "https://media.tenor.com/2/full.gif", "dims": [498, 318]}}},
+    {"id": "3", "content_description": "mp4 only", "itemurl": "https://tenor.com/view/3",
+     "media_formats": {"mp4": {"url": "https://media.tenor.com/3/video.mp4"}}}
+  ]
+}`))
+	}))
+	defer ts.Close()
+
+	g := newTestGifSearcher(t, gifProviderTenor, ts.URL, "pg-13")
+	res, err := g.search(context.Background(), "cat", "pg-13", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"key": "test-key", "q": "cat", "contentfilter": "low"} {
+		if v := got.Get(k); v != want {
+			t.Errorf("query %s = %q, want %q", k, v, want)
+		}
+	}
+	want := []string{"https://media.tenor.com/1/medium.gif", "https://media.tenor.com/2/full.gif"}
+	if urls := imageURLs(res.images); !equalStrings(urls, want) {
+		t.Errorf("images = %v, want %v", urls, want)
+	}
+	if res.total != 2 {
+		t.Errorf("total = %d, want 2", res.total)
+	}
+}
+
+func TestGifSearcherUpstreamError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	g := newTestGifSearcher(t, gifProviderGiphy, ts.URL, "g")
+	if _, err := g.RandomSearch(context.Background(), []string{"cat"}); err == nil {
+		t.Error("expected an error for 403")
+	}
+}
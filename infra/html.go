@@ -0,0 +1,19 @@
+package infra
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// 本文やメタデータに入っている HTML を 1 行のテキストにする。limit が 0 なら切り詰めない
+func htmlToText(s string, limit int) string {
+	text := html.UnescapeString(htmlTagPattern.ReplaceAllString(s, " "))
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); 0 < limit && limit < len(r) {
+		text = string(r[:limit]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,187 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	httpDefaultTimeout = 10 * time.Second
+	httpMaxRetries     = 3
+	httpBaseDelay      = 500 * time.Millisecond
+	httpMaxDelay       = 10 * time.Second
+)
+
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+	URL        string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, e.URL)
+}
+
+// 外向きの HTTP はすべてここを通す。Transport は共有してソースごとに timeout だけ変える
+type httpClient struct {
+	client  *http.Client
+	timeout time.Duration
+	random  repository.Random
+}
+
+func newHTTPClient(random repository.Random) *httpClient {
+	return &httpClient{
+		client:  &http.Client{},
+		timeout: httpDefaultTimeout,
+		random:  random,
+	}
+}
+
+func (c *httpClient) withTimeout(d time.Duration) *httpClient {
+	if d <= 0 {
+		return c
+	}
+	return &httpClient{
+		client:  c.client,
+		timeout: d,
+		random:  c.random,
+	}
+}
+
+func (c *httpClient) Get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// 2xx のときだけ resp を返す。それ以外は body を閉じて source 付きのエラーにする
+func (c *httpClient) getOK(ctx context.Context, source, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.doOK(ctx, source, req)
+}
+
+// ヘッダーを付けたいときは getOK の代わりにこちらを使う
+func (c *httpClient) doOK(ctx context.Context, source string, req *http.Request) (*http.Response, error) {
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		return nil, requestError(source, err)
+	}
+	if err := responseError(source, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// 冪等なリクエストだけ 5xx / 429 / 通信エラーでリトライする
+func (c *httpClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	retryable := req.Method == http.MethodGet || req.Method == http.MethodHead
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(ctx, req)
+		if !retryable || httpMaxRetries <= attempt || ctx.Err() != nil {
+			return resp, err
+		}
+
+		var wait time.Duration
+		if err != nil {
+			wait = c.backoff(attempt)
+		} else if resp.StatusCode == http.StatusTooManyRequests || 500 <= resp.StatusCode {
+			wait = retryAfter(resp.Header.Get("Retry-After"))
+			if wait <= 0 {
+				wait = c.backoff(attempt)
+			}
+			resp.Body.Close()
+		} else {
+			return resp, nil
+		}
+		if httpMaxDelay < wait {
+			wait = httpMaxDelay
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *httpClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	resp, err := c.client.Do(req.Clone(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// body を読み終えるまで timeout の context を生かしておく
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// full jitter: 0 〜 base * 2^attempt の間でランダムに待つ
+func (c *httpClient) backoff(attempt int) time.Duration {
+	max := httpBaseDelay << uint(attempt)
+	if httpMaxDelay < max {
+		max = httpMaxDelay
+	}
+	return time.Duration(c.random.Intn(int(max)) + 1)
+}
+
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// 401 / 403 / 429 / 5xx は repository のエラーに寄せ、それ以外の 2xx 以外もエラーにする。
+// body を先に読みたいときなどで getOK / doOK を使えない呼び出し側が使う
+func responseError(source string, resp *http.Response) error {
+	if err := statusToError(resp.StatusCode); err != nil {
+		return upstreamError(source, err, resp.Status)
+	}
+	return checkStatus(resp)
+}
+
+// 2xx 以外はエラーにする。呼び出し側で body を読む前に使う
+func checkStatus(resp *http.Response) error {
+	if 200 <= resp.StatusCode && resp.StatusCode < 300 {
+		return nil
+	}
+	// api_key などが含まれるのでクエリは落とす
+	u := *resp.Request.URL
+	u.RawQuery = ""
+	return &httpStatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		URL:        u.String(),
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
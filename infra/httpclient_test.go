@@ -0,0 +1,162 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+func newTestHTTPClient(t *testing.T) *httpClient {
+	t.Helper()
+	random, err := newRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newHTTPClient(random)
+}
+
+// statuses を順に返し、尽きたら 200 を返す
+func newStatusServer(statuses []int, header http.Header) (*httptest.Server, *int32) {
+	var n int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&n, 1)) - 1
+		if i < len(statuses) {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(statuses[i])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	return ts, &n
+}
+
+func TestHTTPClientRetriesServerError(t *testing.T) {
+	ts, n := newStatusServer([]int{http.StatusServiceUnavailable}, nil)
+	defer ts.Close()
+
+	resp, err := newTestHTTPClient(t).Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(n); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestHTTPClientHonorsRetryAfter(t *testing.T) {
+	ts, n := newStatusServer([]int{http.StatusTooManyRequests}, http.Header{"Retry-After": {"1"}})
+	defer ts.Close()
+
+	start := time.Now()
+	resp, err := newTestHTTPClient(t).Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least Retry-After", elapsed)
+	}
+	if got := atomic.LoadInt32(n); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+// POST は冪等とは限らないので 5xx でもそのまま返す
+func TestHTTPClientDoesNotRetryPost(t *testing.T) {
+	ts, n := newStatusServer([]int{http.StatusServiceUnavailable}, nil)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := newTestHTTPClient(t).Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(n); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+func TestHTTPClientCanceledWhileWaiting(t *testing.T) {
+	ts, n := newStatusServer([]int{http.StatusServiceUnavailable}, http.Header{"Retry-After": {"5"}})
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestHTTPClient(t).Get(ctx, ts.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); time.Second < elapsed {
+		t.Errorf("returned after %s, want to stop waiting on cancel", elapsed)
+	}
+	if got := atomic.LoadInt32(n); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if got := retryAfter("3"); got != 3*time.Second {
+		t.Errorf("retryAfter(3) = %s", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := retryAfter(date); got <= 50*time.Second || time.Minute < got {
+		t.Errorf("retryAfter(%s) = %s", date, got)
+	}
+	for _, v := range []string{"", "soon"} {
+		if got := retryAfter(v); got != 0 {
+			t.Errorf("retryAfter(%q) = %s, want 0", v, got)
+		}
+	}
+}
+
+func TestHTTPClientGetOK(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusForbidden, repository.ErrorInvalidAPIKey},
+		{http.StatusNotFound, nil},
+		{http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		ts, _ := newStatusServer([]int{tt.status}, nil)
+		resp, err := newTestHTTPClient(t).getOK(context.Background(), "test", ts.URL)
+		switch {
+		case tt.status == http.StatusOK:
+			if err != nil {
+				t.Errorf("status=%d err = %v", tt.status, err)
+			} else {
+				resp.Body.Close()
+			}
+		case err == nil:
+			t.Errorf("status=%d expected an error", tt.status)
+		case tt.want != nil && (!errors.Is(err, tt.want) || !strings.HasPrefix(err.Error(), "test: ")):
+			t.Errorf("status=%d err = %v, want %v with the source prefix", tt.status, err, tt.want)
+		}
+		ts.Close()
+	}
+}
@@ -8,23 +8,83 @@ import (
 )
 
 type store struct {
-	slackAPI       repository.SlackAPI
-	flickrSearcher repository.FlickrSearcher
-	tumblrSearcher repository.TumblrSearcher
-	moeSearcher    repository.MoeSearcher
+	slackAPI         repository.SlackAPI
+	random           repository.Random
+	flickrSearcher   repository.FlickrSearcher
+	tumblrSearcher   repository.TumblrSearcher
+	moeSearcher      repository.MoeSearcher
+	feedSearcher     repository.FeedSearcher
+	mastodonSearcher repository.MastodonSearcher
+	redditSearcher   repository.RedditSearcher
+	gifSearcher      repository.GifSearcher
+	commonsSearcher  repository.CommonsSearcher
+	booruSearcher    repository.BooruSearcher
+	imageFetcher     repository.ImageFetcher
+	dictionary       repository.Dictionary
+	scoreStore       repository.ScoreStore
+	postLog          repository.PostLog
+	dailyStore       repository.DailyStore
+	safety           repository.Safety
 }
 
 func NewRepository(conf config.Config) (repository.Repository, error) {
+	random, err := newRandom()
+	if err != nil {
+		return nil, err
+	}
+	client := newHTTPClient(random)
 	api := slack.New(conf.SlackBotToken())
-	slackAPI, err := newSlackAPI(api)
+	slackAPI, err := newSlackAPI(api, conf.SlackBotToken(), client.withTimeout(conf.SourceTimeout("slack")), conf.EmojiTTL())
+	if err != nil {
+		return nil, err
+	}
+	dictionary, err := newDictionary(conf.DictionaryPath())
+	if err != nil {
+		return nil, err
+	}
+	scoreStore, err := newScoreStore(conf.ScoreStorePath())
+	if err != nil {
+		return nil, err
+	}
+	postLog, err := newPostLog(conf.PostLogPath())
+	if err != nil {
+		return nil, err
+	}
+	dailyStore, err := newDailyStore(conf.DailyStorePath())
+	if err != nil {
+		return nil, err
+	}
+	safety, err := newSafety(conf.DefaultSafetyLevel(), conf.ChannelSafetyLevels(), conf.NegativeKeywords(), conf.BlocklistPath())
+	if err != nil {
+		return nil, err
+	}
+	gazetteer, err := newGazetteer(conf.FlickrGazetteerPath())
+	if err != nil {
+		return nil, err
+	}
+	signing := conf.MoeURLSigning()
+	signer, err := newURLSigner(signing.Scheme, signing.Secret, signing.AccessKeyID, signing.Region, signing.Expiry)
 	if err != nil {
 		return nil, err
 	}
 	return &store{
-		slackAPI:       slackAPI,
-		flickrSearcher: newFlickrSearcher(conf.FlickrAPIToken()),
-		tumblrSearcher: newTumblrSearcher(conf.TumblrAPIToken()),
-		moeSearcher:    newMoeSearcher(conf.MoeURL(), conf.MoeKeys()),
+		slackAPI:         slackAPI,
+		random:           random,
+		flickrSearcher:   newFlickrSearcher(conf.FlickrAPIToken(), conf.FlickrLicenses(), gazetteer, client.withTimeout(conf.SourceTimeout("flickr")), random, scoreStore, safety, conf.FlickrPageCountTTL(), conf.FlickrPageTTL()),
+		tumblrSearcher:   newTumblrSearcher(conf.TumblrAPIToken(), client.withTimeout(conf.SourceTimeout("tumblr")), random, scoreStore, safety, conf.TumblrIndexDir(), conf.TumblrIndexInterval()),
+		moeSearcher:      newMoeSearcher(conf.MoeURL(), conf.MoeKeys(), conf.MoeManifestURL(), conf.MoeRefreshInterval(), signer, client.withTimeout(conf.SourceTimeout("moe")), random, scoreStore, safety),
+		feedSearcher:     newFeedSearcher(client.withTimeout(conf.SourceTimeout("feed")), random, scoreStore, safety, conf.FeedTTL()),
+		mastodonSearcher: newMastodonSearcher(conf.MastodonInstanceURL(), conf.MastodonAccessToken(), conf.MastodonExcludeSensitive(), client.withTimeout(conf.SourceTimeout("mastodon")), random, scoreStore, safety, conf.MastodonTTL()),
+		redditSearcher:   newRedditSearcher(conf.RedditUserAgent(), client.withTimeout(conf.SourceTimeout("reddit")), random, scoreStore, safety, conf.RedditTTL()),
+		gifSearcher:      newGifSearcher(conf.GifProvider(), conf.GifAPIBaseURL(), conf.GifAPIKey(), conf.GifRating(), client.withTimeout(conf.SourceTimeout("gif")), random, scoreStore, safety, conf.GifTTL()),
+		commonsSearcher:  newCommonsSearcher(client.withTimeout(conf.SourceTimeout("commons")), random, scoreStore, safety, conf.CommonsTTL()),
+		booruSearcher:    newBooruSearcher(conf.BooruProvider(), conf.BooruBaseURL(), conf.BooruLogin(), conf.BooruAPIKey(), conf.BooruBlacklist(), client.withTimeout(conf.SourceTimeout("booru")), random, scoreStore, safety),
+		imageFetcher:     newImageFetcher(client.withTimeout(conf.SourceTimeout("fetch")), conf.ImageUploadMaxBytes(), conf.ImageFetchAuth()),
+		dictionary:       dictionary,
+		scoreStore:       scoreStore,
+		postLog:          postLog,
+		dailyStore:       dailyStore,
+		safety:           safety,
 	}, nil
 }
 
@@ -32,6 +92,10 @@ func (r *store) SlackAPI() repository.SlackAPI {
 	return r.slackAPI
 }
 
+func (r *store) Random() repository.Random {
+	return r.random
+}
+
 func (r *store) FlickrSearcher() repository.FlickrSearcher {
 	return r.flickrSearcher
 }
@@ -43,3 +107,60 @@ func (r *store) TumblrSearcher() repository.TumblrSearcher {
 func (r *store) MoeSearcher() repository.MoeSearcher {
 	return r.moeSearcher
 }
+
+func (r *store) FeedSearcher() repository.FeedSearcher {
+	return r.feedSearcher
+}
+
+func (r *store) MastodonSearcher() repository.MastodonSearcher {
+	return r.mastodonSearcher
+}
+
+func (r *store) RedditSearcher() repository.RedditSearcher {
+	return r.redditSearcher
+}
+
+func (r *store) GifSearcher() repository.GifSearcher {
+	return r.gifSearcher
+}
+
+func (r *store) CommonsSearcher() repository.CommonsSearcher {
+	return r.commonsSearcher
+}
+
+func (r *store) BooruSearcher() repository.BooruSearcher {
+	return r.booruSearcher
+}
+
+func (r *store) ImageFetcher() repository.ImageFetcher {
+	return r.imageFetcher
+}
+
+func (r *store) Dictionary() repository.Dictionary {
+	return r.dictionary
+}
+
+func (r *store) ScoreStore() repository.ScoreStore {
+	return r.scoreStore
+}
+
+func (r *store) PostLog() repository.PostLog {
+	return r.postLog
+}
+
+func (r *store) DailyStore() repository.DailyStore {
+	return r.dailyStore
+}
+
+func (r *store) Safety() repository.Safety {
+	return r.safety
+}
+
+func (r *store) CacheStats() []repository.CacheStats {
+	stats := append(r.slackAPI.CacheStats(), r.flickrSearcher.CacheStats()...)
+	stats = append(stats, r.feedSearcher.CacheStats()...)
+	stats = append(stats, r.mastodonSearcher.CacheStats()...)
+	stats = append(stats, r.redditSearcher.CacheStats()...)
+	stats = append(stats, r.gifSearcher.CacheStats()...)
+	return append(stats, r.commonsSearcher.CacheStats()...)
+}
@@ -0,0 +1,194 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	mastodonPageLimit  = 40
+	mastodonPages      = 3
+	mastodonTitleLimit = 100
+)
+
+type mastodonSearcher struct {
+	instanceURL      string
+	accessToken      string
+	excludeSensitive bool
+	client           *httpClient
+	random           repository.Random
+	scores           repository.ScoreStore
+	safety           repository.Safety
+	pages            *ttlCache
+}
+
+func newMastodonSearcher(instanceURL, accessToken string, excludeSensitive bool, client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety, ttl time.Duration) repository.MastodonSearcher {
+	return &mastodonSearcher{
+		instanceURL:      strings.TrimRight(instanceURL, "/"),
+		accessToken:      accessToken,
+		excludeSensitive: excludeSensitive,
+		client:           client,
+		random:           random,
+		scores:           scores,
+		safety:           safety,
+		pages:            newTTLCache("mastodon_pages", ttl, 200),
+	}
+}
+
+type mastodonStatus struct {
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	Sensitive   bool   `json:"sensitive"`
+	SpoilerText string `json:"spoiler_text"`
+	Content     string `json:"content"`
+	Account     struct {
+		Acct        string `json:"acct"`
+		DisplayName string `json:"display_name"`
+	} `json:"account"`
+	Tags []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	MediaAttachments []struct {
+		Type        string `json:"type"`
+		URL         string `json:"url"`
+		Description string `json:"description"`
+		Meta        struct {
+			Original struct {
+				Width  int `json:"width"`
+				Height int `json:"height"`
+			} `json:"original"`
+		} `json:"meta"`
+	} `json:"media_attachments"`
+}
+
+func (s *mastodonStatus) tags() []string {
+	tags := make([]string, 0, len(s.Tags))
+	for _, t := range s.Tags {
+		tags = append(tags, t.Name)
+	}
+	return tags
+}
+
+// 本文は HTML なのでタグを落として短くしておく
+func (s *mastodonStatus) title() string {
+	return htmlToText(s.Content, mastodonTitleLimit)
+}
+
+// gifv は mp4 なので Slack で展開できる image だけにする
+func (s *mastodonStatus) images() []*domain.Image {
+	author := s.Account.DisplayName
+	if author == "" {
+		author = s.Account.Acct
+	} else {
+		author = fmt.Sprintf("%s (@%s)", author, s.Account.Acct)
+	}
+	images := []*domain.Image{}
+	for _, m := range s.MediaAttachments {
+		if m.Type != "image" || m.URL == "" {
+			continue
+		}
+		title := s.title()
+		if title == "" {
+			title = m.Description
+		}
+		images = append(images, &domain.Image{
+			URL:    m.URL,
+			Source: "mastodon",
+			Title:  title,
+			Author: author,
+			Link:   s.URL,
+			Width:  m.Meta.Original.Width,
+			Height: m.Meta.Original.Height,
+			Tags:   s.tags(),
+		})
+	}
+	return images
+}
+
+func (m *mastodonSearcher) RandomSearch(ctx context.Context, hashtags []string) (*domain.Image, error) {
+	if m.instanceURL == "" {
+		return nil, fmt.Errorf("mastodon: instance not configured")
+	}
+	if len(hashtags) == 0 {
+		return nil, repository.ErrorNotFound
+	}
+	random := randomFrom(ctx, m.random)
+	hashtag := strings.TrimPrefix(strings.TrimSpace(hashtags[random.Intn(len(hashtags))]), "#")
+	if hashtag == "" {
+		return nil, repository.ErrorNotFound
+	}
+
+	// 新しい方から数ページ遡って、その中から選ぶ
+	// safe のチャンネルでは設定に関わらず sensitive / CW 付きを出さない
+	excludeSensitive := m.excludeSensitive || repository.SafetyLevelFromContext(ctx) == repository.SafetyLevelSafe
+	images := []*domain.Image{}
+	maxID := ""
+	for i := 0; i < mastodonPages; i++ {
+		statuses, err := m.timeline(ctx, hashtag, maxID)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range statuses {
+			if excludeSensitive && (s.Sensitive || s.SpoilerText != "") {
+				continue
+			}
+			images = append(images, safeImages(ctx, m.safety, s.tags(), s.images())...)
+		}
+		if len(statuses) < mastodonPageLimit {
+			break
+		}
+		maxID = statuses[len(statuses)-1].ID
+	}
+	if len(images) == 0 {
+		return nil, repository.ErrorNotFound
+	}
+	return weightedPick(random, m.scores, images), nil
+}
+
+// https://docs.joinmastodon.org/methods/timelines/#tag
+func (m *mastodonSearcher) timeline(ctx context.Context, hashtag, maxID string) ([]mastodonStatus, error) {
+	params := url.Values{}
+	params.Set("only_media", "true")
+	params.Set("limit", strconv.Itoa(mastodonPageLimit))
+	if maxID != "" {
+		params.Set("max_id", maxID)
+	}
+	u := fmt.Sprintf("%s/api/v1/timelines/tag/%s?%s", m.instanceURL, url.PathEscape(hashtag), params.Encode())
+	if v, ok := m.pages.Get(u); ok {
+		return v.([]mastodonStatus), nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 公開タイムラインを閉じているインスタンスだけトークンが要る
+	if m.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+m.accessToken)
+	}
+	resp, err := m.client.doOK(ctx, "mastodon", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var statuses []mastodonStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, err
+	}
+	m.pages.Set(u, statuses)
+	return statuses, nil
+}
+
+func (m *mastodonSearcher) CacheStats() []repository.CacheStats {
+	return []repository.CacheStats{m.pages.Stats()}
+}
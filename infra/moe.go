@@ -2,37 +2,64 @@ package infra
 
 import (
 	"context"
-	"fmt"
-	"math/rand"
+	"strings"
+	"time"
 
+	"github.com/mix3/iyashi-bot/domain"
 	"github.com/mix3/iyashi-bot/domain/repository"
 )
 
 type moeSearcher struct {
-	moeURL string
-	keys   []string
+	collection *moeCollection
+	signer     urlSigner
+	random     repository.Random
+	scores     repository.ScoreStore
+	safety     repository.Safety
 }
 
-func newMoeSearcher(moeURL string, keys []string) repository.MoeSearcher {
+func newMoeSearcher(moeURL string, keys []string, manifestURL string, refreshInterval time.Duration, signer urlSigner, client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety) repository.MoeSearcher {
 	return &moeSearcher{
-		moeURL: moeURL,
-		keys:   keys,
+		collection: newMoeCollection(moeURL, keys, manifestURL, refreshInterval, signer, client),
+		signer:     signer,
+		random:     random,
+		scores:     scores,
+		safety:     safety,
 	}
 }
 
-func (m *moeSearcher) RandomSearch(ctx context.Context) (repository.MoeRandomSearchResponse, error) {
-	if len(m.keys) == 0 {
+func (m *moeSearcher) RandomSearch(ctx context.Context, tags []string) (*domain.Image, error) {
+	candidates := m.collection.filter(tags)
+	images := make([]*domain.Image, 0, len(candidates))
+	for _, image := range candidates {
+		if m.safety.IsBlocked(image.URL, "", "") {
+			continue
+		}
+		images = append(images, image)
+	}
+	if len(images) == 0 {
 		return nil, repository.ErrorNotFound
 	}
-	return &moeRandomSearchResponse{
-		imageURL: fmt.Sprintf("%s/%s", m.moeURL, m.keys[rand.Intn(len(m.keys))]),
-	}, nil
+	return m.sign(weightedPick(randomFrom(ctx, m.random), m.scores, images))
 }
 
-type moeRandomSearchResponse struct {
-	imageURL string
+// 評価やブロックは署名前の URL で行うので、署名した URL は別に持たせる
+func (m *moeSearcher) sign(image *domain.Image) (*domain.Image, error) {
+	if m.signer == nil || !strings.HasPrefix(image.URL, m.collection.moeURL+"/") {
+		return image, nil
+	}
+	signed, err := m.signer.sign(image.URL, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	res := *image
+	res.SignedURL = signed
+	return &res, nil
 }
 
-func (m *moeRandomSearchResponse) ImageURL() string {
-	return m.imageURL
+func (m *moeSearcher) SignURL(rawURL string) (string, error) {
+	image, err := m.sign(&domain.Image{URL: rawURL})
+	if err != nil {
+		return "", err
+	}
+	return image.DeliveryURL(), nil
 }
@@ -0,0 +1,248 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain"
+)
+
+var moeImageExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// マニフェストの 1 件。url が無ければ key を MoeURL からの相対パスとして扱う
+type moeEntry struct {
+	Key   string   `json:"key"`
+	URL   string   `json:"url"`
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+type s3ListBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+	NextMarker            string   `xml:"NextMarker"`
+	Contents              []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+// 静的な MoeKeys か、JSON のマニフェスト / S3 互換のバケット一覧から読んだ画像を持っておく
+type moeCollection struct {
+	moeURL      string
+	manifestURL string
+	interval    time.Duration
+	// origin を非公開にしているなら一覧やマニフェストも署名して取る
+	signer urlSigner
+	client *httpClient
+
+	mu      sync.RWMutex
+	entries []moeEntry
+}
+
+func newMoeCollection(moeURL string, keys []string, manifestURL string, interval time.Duration, signer urlSigner, client *httpClient) *moeCollection {
+	entries := make([]moeEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, moeEntry{Key: key})
+	}
+	c := &moeCollection{
+		moeURL:      strings.TrimRight(moeURL, "/"),
+		manifestURL: manifestURL,
+		interval:    interval,
+		signer:      signer,
+		client:      client,
+		entries:     entries,
+	}
+	if manifestURL != "" {
+		go c.run()
+	}
+	return c
+}
+
+func (c *moeCollection) snapshot() []moeEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.entries
+}
+
+func (c *moeCollection) replace(entries []moeEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = entries
+}
+
+// interval が 0 なら起動時に一度だけ読む
+func (c *moeCollection) run() {
+	for {
+		entries, err := c.fetch(context.Background())
+		if err != nil {
+			log.Printf("[WARN] moe manifest fetch url=%s err:%s", c.manifestURL, err)
+		} else {
+			c.replace(entries)
+			log.Printf("[INFO] moe manifest url=%s total=%d", c.manifestURL, len(entries))
+		}
+		if c.interval <= 0 {
+			return
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+func (c *moeCollection) fetch(ctx context.Context) ([]moeEntry, error) {
+	body, err := c.get(ctx, c.manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		var entries []moeEntry
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+
+	// S3 の一覧は 1000 件ずつなので続きがあれば辿る
+	entries := []moeEntry{}
+	for {
+		var res s3ListBucketResult
+		if err := xml.Unmarshal(body, &res); err != nil {
+			return nil, err
+		}
+		for _, content := range res.Contents {
+			if isMoeImage(content.Key) {
+				entries = append(entries, moeEntry{
+					Key:  escapeKey(content.Key),
+					Tags: moeKeyTags(content.Key),
+				})
+			}
+		}
+		if !res.IsTruncated {
+			return entries, nil
+		}
+		next, err := s3NextPageURL(c.manifestURL, res)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = c.get(ctx, next); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *moeCollection) get(ctx context.Context, u string) ([]byte, error) {
+	if c.signer != nil {
+		signed, err := c.signer.sign(u, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		u = signed
+	}
+	resp, err := c.client.getOK(ctx, "moe", u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, requestError("moe", err)
+	}
+	return body, nil
+}
+
+// list-type=2 なら continuation-token、v1 なら marker で続きを取る
+func s3NextPageURL(listURL string, res s3ListBucketResult) (string, error) {
+	u, err := url.Parse(listURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	switch {
+	case res.NextContinuationToken != "":
+		q.Set("continuation-token", res.NextContinuationToken)
+	case res.NextMarker != "":
+		q.Set("marker", res.NextMarker)
+	case 0 < len(res.Contents):
+		q.Set("marker", res.Contents[len(res.Contents)-1].Key)
+	default:
+		return "", fmt.Errorf("moe: truncated listing without next marker")
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func isMoeImage(key string) bool {
+	ext := strings.ToLower(path.Ext(key))
+	for _, e := range moeImageExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// バケットにはメタデータが無いのでディレクトリ名をタグにする
+func moeKeyTags(key string) []string {
+	dir := path.Dir(key)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	return strings.Split(strings.Trim(dir, "/"), "/")
+}
+
+func (c *moeCollection) image(e moeEntry) *domain.Image {
+	u := e.URL
+	if u == "" {
+		u = fmt.Sprintf("%s/%s", c.moeURL, strings.TrimLeft(e.Key, "/"))
+	}
+	return &domain.Image{
+		URL:    u,
+		Source: "moe",
+		Title:  e.Title,
+		Tags:   e.Tags,
+	}
+}
+
+// タグかタイトルにすべての単語を含むものだけに絞る
+func (c *moeCollection) filter(words []string) []*domain.Image {
+	images := []*domain.Image{}
+	for _, e := range c.snapshot() {
+		if !matchMoeEntry(e, words) {
+			continue
+		}
+		images = append(images, c.image(e))
+	}
+	return images
+}
+
+// バケットのキーはそのままだと URL に使えない文字を含むことがある
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func matchMoeEntry(e moeEntry, words []string) bool {
+	for _, w := range words {
+		if hasAllTags(e.Tags, []string{w}) {
+			continue
+		}
+		if e.Title != "" && strings.Contains(strings.ToLower(e.Title), strings.ToLower(w)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
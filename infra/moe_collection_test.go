@@ -0,0 +1,234 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMoeCollection(t *testing.T, manifestURL string) *moeCollection {
+	t.Helper()
+	random, err := newRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// manifestURL を渡すと裏で読み込みが始まるので、fetch は直接呼ぶ
+	c := newMoeCollection("https://moe.example.com/", nil, "", 0, nil, newHTTPClient(random))
+	c.manifestURL = manifestURL
+	return c
+}
+
+func TestMoeCollectionJSONManifest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+  {"key": "cats/tama.jpg", "title": "Tama", "tags": ["cat"]},
+  {"url": "https://cdn.example.com/pochi.png", "title": "Pochi", "tags": ["dog"]}
+]`))
+	}))
+	defer ts.Close()
+
+	c := newTestMoeCollection(t, ts.URL+"/manifest.json")
+	entries, err := c.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.replace(entries)
+
+	images := c.filter([]string{"cat"})
+	if len(images) != 1 || images[0].URL != "https://moe.example.com/cats/tama.jpg" || images[0].Title != "Tama" {
+		t.Errorf("cat = %+v", images)
+	}
+	images = c.filter([]string{"pochi"})
+	if len(images) != 1 || images[0].URL != "https://cdn.example.com/pochi.png" {
+		t.Errorf("pochi = %+v", images)
+	}
+	if images := c.filter(nil); len(images) != 2 {
+		t.Errorf("all = %d images, want 2", len(images))
+	}
+}
+
+func s3Page(truncated bool, next string, keys ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	fmt.Fprintf(&b, "<IsTruncated>%v</IsTruncated>", truncated)
+	b.WriteString(next)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "<Contents><Key>%s</Key></Contents>", k)
+	}
+	b.WriteString("</ListBucketResult>")
+	return b.String()
+}
+
+// list-type=2 は continuation-token で続きを辿る
+func TestMoeCollectionS3ListV2(t *testing.T) {
+	requests := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		requests = append(requests, r.URL.RawQuery)
+		if q.Get("list-type") != "2" {
+			t.Errorf("list-type was dropped: %s", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		switch q.Get("continuation-token") {
+		case "":
+			w.Write([]byte(s3Page(true, "<NextContinuationToken>token-1</NextContinuationToken>", "cats/a.jpg", "cats/readme.txt")))
+		case "token-1":
+			w.Write([]byte(s3Page(true, "<NextContinuationToken>token-2</NextContinuationToken>", "dogs/shiba/b.PNG")))
+		case "token-2":
+			w.Write([]byte(s3Page(false, "", "c d.gif")))
+		default:
+			t.Errorf("unexpected token %q", q.Get("continuation-token"))
+		}
+	}))
+	defer ts.Close()
+
+	c := newTestMoeCollection(t, ts.URL+"/?list-type=2&prefix=")
+	entries, err := c.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 {
+		t.Errorf("requests = %v, want 3 pages", requests)
+	}
+	want := []moeEntry{
+		{Key: "cats/a.jpg", Tags: []string{"cats"}},
+		{Key: "dogs/shiba/b.PNG", Tags: []string{"dogs", "shiba"}},
+		{Key: "c%20d.gif"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i].Key != want[i].Key || !equalStrings(entries[i].Tags, want[i].Tags) {
+			t.Errorf("entries[%d] = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+	c.replace(entries)
+	if images := c.filter([]string{"shiba"}); len(images) != 1 || images[0].URL != "https://moe.example.com/dogs/shiba/b.PNG" {
+		t.Errorf("shiba = %+v", images)
+	}
+}
+
+// v1 は NextMarker、それも無ければ最後のキーを marker にする
+func TestMoeCollectionS3ListV1(t *testing.T) {
+	markers := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		marker := r.URL.Query().Get("marker")
+		markers = append(markers, marker)
+		switch marker {
+		case "":
+			w.Write([]byte(s3Page(true, "<NextMarker>a.jpg</NextMarker>", "a.jpg")))
+		case "a.jpg":
+			w.Write([]byte(s3Page(true, "", "b.jpg")))
+		case "b.jpg":
+			w.Write([]byte(s3Page(false, "", "c.jpg")))
+		default:
+			t.Errorf("unexpected marker %q", marker)
+		}
+	}))
+	defer ts.Close()
+
+	c := newTestMoeCollection(t, ts.URL+"/")
+	entries, err := c.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(markers, []string{"", "a.jpg", "b.jpg"}) {
+		t.Errorf("markers = %v", markers)
+	}
+	if len(entries) != 3 {
+		t.Errorf("entries = %+v, want 3", entries)
+	}
+}
+
+func TestS3NextPageURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		listURL string
+		res     s3ListBucketResult
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "continuation token",
+			listURL: "https://bucket.example.com/?list-type=2&prefix=cats%2F",
+			res:     s3ListBucketResult{NextContinuationToken: "abc+/="},
+			want:    "https://bucket.example.com/?continuation-token=abc%2B%2F%3D&list-type=2&prefix=cats%2F",
+		},
+		{
+			name:    "next marker replaces previous marker",
+			listURL: "https://bucket.example.com/?marker=old",
+			res:     s3ListBucketResult{NextMarker: "new"},
+			want:    "https://bucket.example.com/?marker=new",
+		},
+		{
+			name:    "truncated without markers",
+			listURL: "https://bucket.example.com/",
+			res:     s3ListBucketResult{IsTruncated: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s3NextPageURL(tt.listURL, tt.res)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// origin を閉じていても一覧は署名して取れる。続きのページも毎回署名し直す
+func TestMoeCollectionSignedListing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("X-Amz-Signature") == "" || q.Get("X-Amz-Credential") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch q.Get("continuation-token") {
+		case "":
+			w.Write([]byte(s3Page(true, "<NextContinuationToken>token-1</NextContinuationToken>", "a.jpg")))
+		case "token-1":
+			w.Write([]byte(s3Page(false, "", "b.jpg")))
+		}
+	}))
+	defer ts.Close()
+
+	c := newTestMoeCollection(t, ts.URL+"/?list-type=2")
+	c.signer = &s3Presigner{accessKeyID: "AKID", secret: "secret", region: "us-east-1", expiry: time.Minute}
+	entries, err := c.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("entries = %+v, want 2", entries)
+	}
+}
+
+func TestMoeCollectionFetchError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<Error><Code>AccessDenied</Code></Error>`))
+	}))
+	defer ts.Close()
+
+	c := newTestMoeCollection(t, ts.URL+"/")
+	if _, err := c.fetch(context.Background()); err == nil {
+		t.Error("expected an error for 403")
+	}
+}
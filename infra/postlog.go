@@ -0,0 +1,93 @@
+package infra
+
+import (
+	"sync"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	postLogLimit = 1000
+)
+
+type postLog struct {
+	mu    sync.RWMutex
+	path  string
+	posts []repository.Post
+}
+
+func newPostLog(path string) (repository.PostLog, error) {
+	p := &postLog{
+		path: path,
+	}
+	if err := loadJSONFile(path, &p.posts); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *postLog) Record(post repository.Post) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.posts = append(p.posts, post)
+	if n := len(p.posts); postLogLimit < n {
+		p.posts = p.posts[n-postLogLimit:]
+	}
+	return saveJSONFile(p.path, p.posts)
+}
+
+func (p *postLog) Find(channel, ts string) (repository.Post, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for i := len(p.posts) - 1; 0 <= i; i-- {
+		if post := p.posts[i]; post.Channel == channel && post.TS == ts {
+			return post, true
+		}
+	}
+	return repository.Post{}, false
+}
+
+// user が空なら誰のリクエストでも対象にする
+func (p *postLog) LastImage(channel, user string) (repository.Post, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for i := len(p.posts) - 1; 0 <= i; i-- {
+		post := p.posts[i]
+		if post.Channel != channel || post.ImageURL == "" || post.TS == "" {
+			continue
+		}
+		if user == "" || post.User == user {
+			return post, true
+		}
+	}
+	return repository.Post{}, false
+}
+
+func (p *postLog) LastRequestedImage(channel string) (repository.Post, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for i := len(p.posts) - 1; 0 <= i; i-- {
+		post := p.posts[i]
+		if post.ImageURL == "" || post.TS == "" {
+			continue
+		}
+		if post.Channel == channel || post.RequestChannel == channel {
+			return post, true
+		}
+	}
+	return repository.Post{}, false
+}
+
+func (p *postLog) Remove(channel, ts string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	posts := p.posts[:0]
+	for _, post := range p.posts {
+		if post.Channel == channel && post.TS == ts {
+			continue
+		}
+		posts = append(posts, post)
+	}
+	p.posts = posts
+	return saveJSONFile(p.path, p.posts)
+}
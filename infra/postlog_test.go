@@ -0,0 +1,29 @@
+package infra
+
+import (
+	"testing"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+// ts のわからない投稿は消せないので、最後の画像として選ばない
+func TestPostLogSkipsPostsWithoutTS(t *testing.T) {
+	p, err := newPostLog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, post := range []repository.Post{
+		{Channel: "C1", TS: "1.0", User: "U1", ImageURL: "https://example.com/1.jpg", FileID: "F1"},
+		{Channel: "C1", TS: "", User: "U1", ImageURL: "https://example.com/2.jpg", FileID: "F2"},
+	} {
+		if err := p.Record(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if post, ok := p.LastImage("C1", "U1"); !ok || post.TS != "1.0" || post.FileID != "F1" {
+		t.Errorf("LastImage = %+v, %v", post, ok)
+	}
+	if post, ok := p.LastRequestedImage("C1"); !ok || post.TS != "1.0" {
+		t.Errorf("LastRequestedImage = %+v, %v", post, ok)
+	}
+}
@@ -0,0 +1,42 @@
+package infra
+
+import (
+	"context"
+	crand "crypto/rand"
+	"math"
+	"math/big"
+	"math/rand"
+	"sync"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+// rand.Rand は goroutine safe じゃないのでロックする
+type lockedRandom struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newRandom() (repository.Random, error) {
+	seed, err := crand.Int(crand.Reader, big.NewInt(math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+	return &lockedRandom{
+		r: rand.New(rand.NewSource(seed.Int64())),
+	}, nil
+}
+
+func (l *lockedRandom) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+// context で乱数源が指定されていればそちらを優先する
+func randomFrom(ctx context.Context, def repository.Random) repository.Random {
+	if r, ok := repository.RandomFromContext(ctx); ok {
+		return r
+	}
+	return def
+}
@@ -0,0 +1,214 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	redditPageLimit = 100
+	// reddit は UA が無いとすぐ 429 を返してくる
+	redditDefaultUserAgent = "iyashi-bot/1.0"
+)
+
+type redditSearcher struct {
+	userAgent string
+	client    *httpClient
+	random    repository.Random
+	scores    repository.ScoreStore
+	safety    repository.Safety
+	listings  *ttlCache
+}
+
+func newRedditSearcher(userAgent string, client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety, ttl time.Duration) repository.RedditSearcher {
+	if userAgent == "" {
+		userAgent = redditDefaultUserAgent
+	}
+	return &redditSearcher{
+		userAgent: userAgent,
+		client:    client,
+		random:    random,
+		scores:    scores,
+		safety:    safety,
+		listings:  newTTLCache("reddit_listings", ttl, 100),
+	}
+}
+
+type redditMedia struct {
+	Status string `json:"status"`
+	E      string `json:"e"`
+	S      struct {
+		U   string `json:"u"`
+		Gif string `json:"gif"`
+		X   int    `json:"x"`
+		Y   int    `json:"y"`
+	} `json:"s"`
+}
+
+type redditPost struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	Permalink string `json:"permalink"`
+	URL       string `json:"url"`
+	Over18    bool   `json:"over_18"`
+	// subreddit 単位の広告区分。promo_adult_nsfw などは成人向け扱い
+	WhitelistStatus string `json:"parent_whitelist_status"`
+	Quarantine      bool   `json:"quarantine"`
+	Spoiler         bool   `json:"spoiler"`
+	PostHint        string `json:"post_hint"`
+	Flair           string `json:"link_flair_text"`
+	IsGallery       bool   `json:"is_gallery"`
+	// media_metadata は順番が無いので gallery_data の並びで読む
+	GalleryData struct {
+		Items []struct {
+			MediaID string `json:"media_id"`
+		} `json:"items"`
+	} `json:"gallery_data"`
+	MediaMetadata map[string]redditMedia `json:"media_metadata"`
+	Preview       struct {
+		Images []struct {
+			Source struct {
+				URL    string `json:"url"`
+				Width  int    `json:"width"`
+				Height int    `json:"height"`
+			} `json:"source"`
+		} `json:"images"`
+	} `json:"preview"`
+}
+
+type redditListing struct {
+	Data struct {
+		Children []struct {
+			Data redditPost `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+func (p *redditPost) image(u string, width, height int) *domain.Image {
+	tags := []string{}
+	if p.Flair != "" {
+		tags = append(tags, p.Flair)
+	}
+	return &domain.Image{
+		URL:    html.UnescapeString(u),
+		Source: "reddit",
+		Title:  p.Title,
+		Author: "u/" + p.Author,
+		Link:   "https://www.reddit.com" + p.Permalink,
+		Width:  width,
+		Height: height,
+		Tags:   tags,
+	}
+}
+
+// i.redd.it などの直リンクと gallery を画像にする。動画や外部サイトへのリンクは捨てる
+func (p *redditPost) images() []*domain.Image {
+	if p.IsGallery {
+		images := []*domain.Image{}
+		for _, item := range p.GalleryData.Items {
+			m, ok := p.MediaMetadata[item.MediaID]
+			if !ok || m.Status != "valid" || m.E != "Image" && m.E != "AnimatedImage" {
+				continue
+			}
+			u := m.S.U
+			if u == "" {
+				u = m.S.Gif
+			}
+			if u != "" {
+				images = append(images, p.image(u, m.S.X, m.S.Y))
+			}
+		}
+		return images
+	}
+	if p.PostHint != "image" && !isImageURL(p.URL) {
+		return nil
+	}
+	width, height := 0, 0
+	if 0 < len(p.Preview.Images) {
+		width, height = p.Preview.Images[0].Source.Width, p.Preview.Images[0].Source.Height
+	}
+	return []*domain.Image{p.image(p.URL, width, height)}
+}
+
+func (r *redditSearcher) RandomSearch(ctx context.Context, subreddit, sort string) (*domain.Image, error) {
+	posts, err := r.listing(ctx, subreddit, sort)
+	if err != nil {
+		return nil, err
+	}
+	strict := repository.SafetyLevelFromContext(ctx) == repository.SafetyLevelSafe
+	images := []*domain.Image{}
+	for _, p := range posts {
+		if p.Over18 || p.Spoiler {
+			continue
+		}
+		if strict && (p.Quarantine || strings.HasPrefix(p.WhitelistStatus, "promo_adult")) {
+			continue
+		}
+		images = append(images, safeImages(ctx, r.safety, []string{p.Title, p.Flair}, p.images())...)
+	}
+	if len(images) == 0 {
+		return nil, repository.ErrorNotFound
+	}
+	return weightedPick(randomFrom(ctx, r.random), r.scores, images), nil
+}
+
+// https://www.reddit.com/dev/api#GET_hot
+func (r *redditSearcher) listing(ctx context.Context, subreddit, sort string) ([]redditPost, error) {
+	if sort == "" {
+		sort = "hot"
+	}
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(redditPageLimit))
+	params.Set("raw_json", "1")
+	if sort == "top" {
+		params.Set("t", "month")
+	}
+	u := fmt.Sprintf("https://www.reddit.com/r/%s/%s.json?%s", url.PathEscape(subreddit), sort, params.Encode())
+	if v, ok := r.listings.Get(u); ok {
+		return v.([]redditPost), nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", r.userAgent)
+	resp, err := r.client.Do(ctx, req)
+	if err != nil {
+		return nil, requestError("reddit", err)
+	}
+	defer resp.Body.Close()
+	// 存在しない / 非公開の subreddit は 404 / 403 になる
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		return nil, upstreamError("reddit", repository.ErrorBlogNotFound, fmt.Sprintf("subreddit=%s %s", subreddit, resp.Status))
+	}
+	if err := responseError("reddit", resp); err != nil {
+		return nil, err
+	}
+
+	var listing redditListing
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, err
+	}
+	posts := make([]redditPost, 0, len(listing.Data.Children))
+	for _, c := range listing.Data.Children {
+		posts = append(posts, c.Data)
+	}
+	r.listings.Set(u, posts)
+	return posts, nil
+}
+
+func (r *redditSearcher) CacheStats() []repository.CacheStats {
+	return []repository.CacheStats{r.listings.Stats()}
+}
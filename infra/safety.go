@@ -0,0 +1,142 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+type blocklist struct {
+	URLs     []string `json:"urls"`
+	PhotoIDs []string `json:"photo_ids"`
+	Owners   []string `json:"owners"`
+}
+
+type safety struct {
+	defaultLevel     repository.SafetyLevel
+	channelLevels    map[string]repository.SafetyLevel
+	negativeKeywords []string
+
+	mu        sync.RWMutex
+	path      string
+	blocklist blocklist
+	urls      map[string]bool
+	photoIDs  map[string]bool
+	owners    map[string]bool
+}
+
+func newSafety(defaultLevel string, channelLevels map[string]string, negativeKeywords []string, blocklistPath string) (repository.Safety, error) {
+	s := &safety{
+		channelLevels:    map[string]repository.SafetyLevel{},
+		negativeKeywords: negativeKeywords,
+		path:             blocklistPath,
+		urls:             map[string]bool{},
+		photoIDs:         map[string]bool{},
+		owners:           map[string]bool{},
+	}
+
+	level, ok := repository.ParseSafetyLevel(defaultLevel)
+	if !ok {
+		return nil, fmt.Errorf("unknown safety level: %s", defaultLevel)
+	}
+	s.defaultLevel = level
+	for channel, v := range channelLevels {
+		level, ok := repository.ParseSafetyLevel(v)
+		if !ok {
+			return nil, fmt.Errorf("unknown safety level: channel=%s level=%s", channel, v)
+		}
+		s.channelLevels[channel] = level
+	}
+
+	if err := loadJSONFile(blocklistPath, &s.blocklist); err != nil {
+		return nil, err
+	}
+	for _, v := range s.blocklist.URLs {
+		s.urls[v] = true
+	}
+	for _, v := range s.blocklist.PhotoIDs {
+		s.photoIDs[v] = true
+	}
+	for _, v := range s.blocklist.Owners {
+		s.owners[v] = true
+	}
+	return s, nil
+}
+
+func (s *safety) Level(channel string) repository.SafetyLevel {
+	if level, ok := s.channelLevels[channel]; ok {
+		return level
+	}
+	return s.defaultLevel
+}
+
+func (s *safety) NegativeKeywords() []string {
+	return s.negativeKeywords
+}
+
+func (s *safety) IsBlocked(url, photoID, owner string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return (url != "" && s.urls[url]) ||
+		(photoID != "" && s.photoIDs[photoID]) ||
+		(owner != "" && s.owners[owner])
+}
+
+func (s *safety) BlockURL(url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.urls[url] {
+		return nil
+	}
+	s.urls[url] = true
+	s.blocklist.URLs = append(s.blocklist.URLs, url)
+	return saveJSONFile(s.path, s.blocklist)
+}
+
+// サイズ違いの URL もまとめて弾けるように写真の ID で止める
+func (s *safety) BlockPhotoID(photoID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.photoIDs[photoID] {
+		return nil
+	}
+	s.photoIDs[photoID] = true
+	s.blocklist.PhotoIDs = append(s.blocklist.PhotoIDs, photoID)
+	return saveJSONFile(s.path, s.blocklist)
+}
+
+// タグなどにネガティブワードが含まれていたら弾く
+func containsNegativeKeyword(negativeKeywords []string, words []string) bool {
+	for _, w := range words {
+		w = strings.ToLower(w)
+		for _, n := range negativeKeywords {
+			if strings.Contains(w, strings.ToLower(n)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// safe のチャンネルではタグに加えてタイトルもネガティブワードで見る
+func safeImages(ctx context.Context, safety repository.Safety, tags []string, images []*domain.Image) []*domain.Image {
+	if containsNegativeKeyword(safety.NegativeKeywords(), tags) {
+		return nil
+	}
+	strict := repository.SafetyLevelFromContext(ctx) == repository.SafetyLevelSafe
+	res := make([]*domain.Image, 0, len(images))
+	for _, image := range images {
+		if safety.IsBlocked(image.URL, "", "") {
+			continue
+		}
+		if strict && containsNegativeKeyword(safety.NegativeKeywords(), []string{image.Title}) {
+			continue
+		}
+		res = append(res, image)
+	}
+	return res
+}
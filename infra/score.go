@@ -0,0 +1,127 @@
+package infra
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+const (
+	scoreBaseWeight    = 10
+	scoreLikeWeight    = 2
+	scoreSuppressBelow = -3
+)
+
+type scoreData struct {
+	ImageScores map[string]int `json:"image_scores"`
+	QueryScores map[string]int `json:"query_scores"`
+}
+
+type scoreStore struct {
+	mu   sync.RWMutex
+	path string
+	data scoreData
+}
+
+func newScoreStore(path string) (repository.ScoreStore, error) {
+	s := &scoreStore{
+		path: path,
+	}
+	if err := loadJSONFile(path, &s.data); err != nil {
+		return nil, err
+	}
+	if s.data.ImageScores == nil {
+		s.data.ImageScores = map[string]int{}
+	}
+	if s.data.QueryScores == nil {
+		s.data.QueryScores = map[string]int{}
+	}
+	return s, nil
+}
+
+func (s *scoreStore) AddScore(imageURL, query string, delta int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.ImageScores[imageURL] += delta
+	if query != "" {
+		s.data.QueryScores[query] += delta
+	}
+	return saveJSONFile(s.path, s.data)
+}
+
+func (s *scoreStore) Score(imageURL string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.ImageScores[imageURL]
+}
+
+func (s *scoreStore) QueryScore(query string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.QueryScores[query]
+}
+
+func (s *scoreStore) Top(n int) []repository.ScoredImage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]repository.ScoredImage, 0, len(s.data.ImageScores))
+	for u, score := range s.data.ImageScores {
+		if 0 < score {
+			res = append(res, repository.ScoredImage{ImageURL: u, Score: score})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score == res[j].Score {
+			return res[i].ImageURL < res[j].ImageURL
+		}
+		return res[i].Score > res[j].Score
+	})
+	if n < len(res) {
+		res = res[:n]
+	}
+	return res
+}
+
+// 評価の高い画像ほど選ばれやすく、嫌われた画像は他に候補がある限り選ばない
+func weightedPick(random repository.Random, scores repository.ScoreStore, images []*domain.Image) *domain.Image {
+	return images[weightedIndex(random, len(images), func(i int) int {
+		return scores.Score(images[i].URL)
+	})]
+}
+
+// 指定が無いときの検索ワードも、そのワードで検索した結果の評価で選びやすさを変える
+func weightedWord(random repository.Random, scores repository.ScoreStore, words []string) string {
+	return words[weightedIndex(random, len(words), func(i int) int {
+		return scores.QueryScore(words[i])
+	})]
+}
+
+func weightedIndex(random repository.Random, n int, score func(i int) int) int {
+	weights := make([]int, n)
+	total := 0
+	for i := range weights {
+		s := score(i)
+		if s <= scoreSuppressBelow {
+			continue
+		}
+		w := scoreBaseWeight + scoreLikeWeight*s
+		if w < 1 {
+			w = 1
+		}
+		weights[i] = w
+		total += w
+	}
+	if total == 0 {
+		return random.Intn(n)
+	}
+	r := random.Intn(total)
+	for i, w := range weights {
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	return n - 1
+}
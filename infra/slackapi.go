@@ -1,44 +1,229 @@
 package infra
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mix3/iyashi-bot/domain/repository"
 	"github.com/slack-go/slack"
 )
 
+const (
+	slackAPIURL       = "https://slack.com/api/"
+	slackShareRetries = 5
+	slackShareWait    = 500 * time.Millisecond
+)
+
 type slackAPI struct {
 	api    *slack.Client
+	apiURL string
+	token  string
+	client *httpClient
 	userID string
+	emoji  *ttlCache
 }
 
-func newSlackAPI(api *slack.Client) (repository.SlackAPI, error) {
+func newSlackAPI(api *slack.Client, token string, client *httpClient, emojiTTL time.Duration) (repository.SlackAPI, error) {
 	res, err := api.AuthTest()
 	if err != nil {
 		return nil, err
 	}
 	return &slackAPI{
 		api:    api,
+		apiURL: slackAPIURL,
+		token:  token,
+		client: client,
 		userID: res.UserID,
+		emoji:  newTTLCache("slack_emoji", emojiTTL, 1),
 	}, nil
 }
 
-func (s *slackAPI) PostMessage(ctx context.Context, channel, text string) error {
-	_, _, err := s.api.PostMessageContext(ctx, channel, slack.MsgOptionText(text, true))
-	return err
+// text はエスケープ済みのものを渡す。<#channel> などのリンクを使えるようにそのまま送る
+func (s *slackAPI) PostMessage(ctx context.Context, channel, text string) (repository.Message, error) {
+	ch, ts, err := s.api.PostMessageContext(ctx, channel, slack.MsgOptionText(text, false))
+	return repository.Message{Channel: ch, TS: ts}, err
 }
 
-func (s *slackAPI) DirectMessage(ctx context.Context, user, text string) error {
-	_, _, err := s.api.PostMessageContext(ctx, user, slack.MsgOptionText(text, true))
-	return err
+func (s *slackAPI) DirectMessage(ctx context.Context, user, text string) (repository.Message, error) {
+	ch, ts, err := s.api.PostMessageContext(ctx, user, slack.MsgOptionText(text, false))
+	return repository.Message{Channel: ch, TS: ts}, err
+}
+
+func (s *slackAPI) Reply(ctx context.Context, channel, user, text string) (repository.Message, error) {
+	ch, ts, err := s.api.PostMessageContext(ctx, channel, slack.MsgOptionText(fmt.Sprintf("<@%s> %s", user, text), false))
+	return repository.Message{Channel: ch, TS: ts}, err
+}
+
+// 画像を大きく出したいので image block で送る。text は通知とブロックの上に出る
+func (s *slackAPI) ReplyImageBlock(ctx context.Context, channel, user, text, imageURL, altText string) (repository.Message, error) {
+	text = fmt.Sprintf("<@%s> %s", user, text)
+	ch, ts, err := s.api.PostMessageContext(ctx, channel,
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionBlocks(
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+			slack.NewImageBlock(imageURL, altText, "", nil),
+		),
+	)
+	return repository.Message{Channel: ch, TS: ts}, err
+}
+
+// files.upload は廃止されたので、アップロード先の URL をもらって送ってから共有する
+// https://api.slack.com/messaging/files#upload
+func (s *slackAPI) UploadFile(ctx context.Context, channel, comment string, file repository.File) (repository.Message, error) {
+	var ticket struct {
+		slackResponse
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	if err := s.call(ctx, "files.getUploadURLExternal", url.Values{
+		"filename": {file.Name},
+		"length":   {strconv.Itoa(len(file.Data))},
+	}, &ticket); err != nil {
+		return repository.Message{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ticket.UploadURL, bytes.NewReader(file.Data))
+	if err != nil {
+		return repository.Message{}, err
+	}
+	if file.ContentType != "" {
+		req.Header.Set("Content-Type", file.ContentType)
+	}
+	resp, err := s.client.Do(ctx, req)
+	if err != nil {
+		return repository.Message{}, requestError("slack", err)
+	}
+	resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return repository.Message{}, err
+	}
+
+	files, err := json.Marshal([]map[string]string{{"id": ticket.FileID, "title": file.Name}})
+	if err != nil {
+		return repository.Message{}, err
+	}
+	params := url.Values{
+		"files":      {string(files)},
+		"channel_id": {channel},
+	}
+	if comment != "" {
+		params.Set("initial_comment", comment)
+	}
+	var completed slackResponse
+	if err := s.call(ctx, "files.completeUploadExternal", params, &completed); err != nil {
+		return repository.Message{}, err
+	}
+	return s.sharedMessage(ctx, ticket.FileID, channel), nil
+}
+
+// completeUploadExternal はユーザー ID を受け付けないので先に DM を開いておく
+func (s *slackAPI) DirectUploadFile(ctx context.Context, user, comment string, file repository.File) (repository.Message, error) {
+	ch, _, _, err := s.api.OpenConversationContext(ctx, &slack.OpenConversationParameters{Users: []string{user}})
+	if err != nil {
+		return repository.Message{}, err
+	}
+	return s.UploadFile(ctx, ch.ID, comment, file)
+}
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (r *slackResponse) err() error {
+	if r.OK {
+		return nil
+	}
+	return fmt.Errorf("slack: %s", r.Error)
+}
+
+// slack-go が対応していない Web API を直接叩く
+func (s *slackAPI) call(ctx context.Context, method string, params url.Values, v interface{ err() error }) error {
+	req, err := http.NewRequest(http.MethodPost, s.apiURL+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := s.client.Do(ctx, req)
+	if err != nil {
+		return requestError("slack", err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return err
+	}
+	return v.err()
 }
 
-func (s *slackAPI) Reply(ctx context.Context, channel, user, text string) error {
-	_, _, err := s.api.PostMessageContext(ctx, channel, slack.MsgOptionText(fmt.Sprintf("<@%s> %s", user, text), false))
+// 共有は非同期に行われるので、files.info に投稿の ts が載るまで少し待つ。
+// 取れなければ ts は空のままにする
+func (s *slackAPI) sharedMessage(ctx context.Context, fileID, channel string) repository.Message {
+	for i := 0; i < slackShareRetries; i++ {
+		f, _, _, err := s.api.GetFileInfoContext(ctx, fileID, 0, 0)
+		if err == nil {
+			for _, shares := range []map[string][]slack.ShareFileInfo{f.Shares.Public, f.Shares.Private} {
+				if infos := shares[channel]; 0 < len(infos) {
+					return repository.Message{Channel: channel, TS: infos[0].Ts, FileID: fileID}
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return repository.Message{Channel: channel, FileID: fileID}
+		case <-time.After(slackShareWait):
+		}
+	}
+	return repository.Message{Channel: channel, FileID: fileID}
+}
+
+func (s *slackAPI) DeleteMessage(ctx context.Context, channel, ts string) error {
+	_, _, err := s.api.DeleteMessageContext(ctx, channel, ts)
 	return err
 }
 
+func (s *slackAPI) DeleteFile(ctx context.Context, fileID string) error {
+	return s.api.DeleteFileContext(ctx, fileID)
+}
+
+// emoji.list は重いのでまとめてキャッシュしておく。
+// alias は元の絵文字と同じ画像になるので除く
+func (s *slackAPI) ListEmoji(ctx context.Context) ([]repository.Emoji, error) {
+	if v, ok := s.emoji.Get("emoji"); ok {
+		return v.([]repository.Emoji), nil
+	}
+	res, err := s.api.GetEmojiContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	emoji := make([]repository.Emoji, 0, len(res))
+	for name, u := range res {
+		if strings.HasPrefix(u, "alias:") {
+			continue
+		}
+		emoji = append(emoji, repository.Emoji{Name: name, URL: u})
+	}
+	// map の順番に依らず選べるように並べておく
+	sort.Slice(emoji, func(i, j int) bool { return emoji[i].Name < emoji[j].Name })
+	s.emoji.Set("emoji", emoji)
+	return emoji, nil
+}
+
 func (s *slackAPI) UserID() string {
 	return s.userID
 }
+
+func (s *slackAPI) CacheStats() []repository.CacheStats {
+	return []repository.CacheStats{s.emoji.Stats()}
+}
@@ -4,62 +4,104 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mix3/iyashi-bot/domain"
 	"github.com/mix3/iyashi-bot/domain/repository"
 )
 
 const (
 	tumblrPageLimit = 20
+	// alt sizes からはこの幅以下で一番大きいものを選ぶ
+	tumblrMaxWidth     = 1280
+	tumblrCaptionLimit = 100
 )
 
 type tumblrSearcher struct {
-	token string
+	token   string
+	client  *httpClient
+	random  repository.Random
+	scores  repository.ScoreStore
+	safety  repository.Safety
+	indexer *tumblrIndexer
 }
 
-func newTumblrSearcher(token string) repository.TumblrSearcher {
-	return &tumblrSearcher{
-		token: token,
+func newTumblrSearcher(token string, client *httpClient, random repository.Random, scores repository.ScoreStore, safety repository.Safety, indexDir string, indexInterval time.Duration) repository.TumblrSearcher {
+	t := &tumblrSearcher{
+		token:  token,
+		client: client,
+		random: random,
+		scores: scores,
+		safety: safety,
 	}
+	t.indexer = newTumblrIndexer(t, indexDir, indexInterval)
+	return t
 }
 
-func (t *tumblrSearcher) RandomSearch(ctx context.Context, tumblrID string, tags []string) (repository.TumblrRandomSearchResponse, error) {
-	res, err := t.search(ctx, tumblrID, tags, 0)
+func (t *tumblrSearcher) Watch(tumblrID string) {
+	t.indexer.watch(tumblrID)
+}
+
+func (t *tumblrSearcher) RandomSearch(ctx context.Context, tumblrID string, tags []string) (*domain.Image, error) {
+	images, err := t.randomSearch(ctx, tumblrID, tags, false)
+	if err != nil {
+		return nil, err
+	}
+	return images[0], nil
+}
+
+func (t *tumblrSearcher) RandomPhotoset(ctx context.Context, tumblrID string, tags []string) ([]*domain.Image, error) {
+	return t.randomSearch(ctx, tumblrID, tags, true)
+}
+
+// photoset なら投稿単位で選んでその投稿の画像をすべて返す
+func (t *tumblrSearcher) randomSearch(ctx context.Context, tumblrID string, tags []string, photoset bool) ([]*domain.Image, error) {
+	random := randomFrom(ctx, t.random)
+	pick := func(posts []tumblrIndexedPost) []*domain.Image {
+		if photoset {
+			return randomPost(ctx, posts, random, t.safety)
+		}
+		return randomPhoto(ctx, posts, random, t.scores, t.safety)
+	}
+
+	// インデックスができていればそこから一様に選ぶ
+	if idx, ok := t.indexer.index(tumblrID); ok {
+		if images := pick(idx.filter(tags)); 0 < len(images) {
+			return images, nil
+		}
+		return nil, repository.ErrorNotFound
+	}
+
+	res, err := t.search(ctx, tumblrID, tags, 0, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	var photoURL string
 	for i := 0; i < 3; i++ {
 		n := res.Response.TotalPosts - tumblrPageLimit + 1
-		if n < 0 {
-			break
+		if n < 1 {
+			n = 1
 		}
-		offset := rand.Intn(n)
-		res, err = t.search(ctx, tumblrID, tags, offset)
+		offset := random.Intn(n)
+		res, err = t.search(ctx, tumblrID, tags, offset, 0)
 		if err != nil {
 			return nil, err
 		}
-		photoURL = res.RandomPhotoURL()
-		if photoURL != "" {
-			break
+		if images := pick(res.indexedPosts()); 0 < len(images) {
+			return images, nil
 		}
 	}
-	if photoURL == "" {
-		return nil, repository.ErrorNotFound
-	}
-
-	return &tumblrRandomSearchResponse{
-		photoURL: photoURL,
-	}, nil
+	return nil, repository.ErrorNotFound
 }
 
-func (t *tumblrSearcher) search(ctx context.Context, tumblrID string, tags []string, offset int) (*TumblrSearchResponse, error) {
-	baseURL := fmt.Sprintf("http://api.tumblr.com/v2/blog/%s.tumblr.com/posts/photo", tumblrID)
+func (t *tumblrSearcher) search(ctx context.Context, tumblrID string, tags []string, offset int, before int64) (*TumblrSearchResponse, error) {
+	// テキスト投稿に貼られた画像も拾えるように type で絞らず NPF で受け取る
+	baseURL := fmt.Sprintf("https://api.tumblr.com/v2/blog/%s.tumblr.com/posts", tumblrID)
 	u, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
@@ -68,58 +110,210 @@ func (t *tumblrSearcher) search(ctx context.Context, tumblrID string, tags []str
 	params := url.Values{}
 	params.Set("api_key", t.token)
 	params.Set("limit", strconv.Itoa(tumblrPageLimit))
+	params.Set("npf", "true")
 	if 0 < offset {
 		params.Set("offset", strconv.Itoa(offset))
 	}
+	if 0 < before {
+		params.Set("before", strconv.FormatInt(before, 10))
+	}
 	if 0 < len(tags) {
 		params.Set("tag", strings.Join(tags, "+"))
 	}
 
 	u.RawQuery = params.Encode()
 
-	resp, err := http.Get(u.String())
+	resp, err := t.client.Get(ctx, u.String())
 	if err != nil {
-		return nil, err
+		return nil, requestError("tumblr", err)
 	}
 	defer resp.Body.Close()
 
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, requestError("tumblr", err)
+	}
+	// エラーのときは response が [] になるので meta だけ先に読む
+	var meta tumblrMeta
+	if err := json.Unmarshal(body, &meta); err == nil && meta.Meta.Status != 0 && meta.Meta.Status != http.StatusOK {
+		return nil, meta.err(tumblrID)
+	}
+	if err := responseError("tumblr", resp); err != nil {
+		return nil, err
+	}
+
 	var res *TumblrSearchResponse
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+	if err := json.Unmarshal(body, &res); err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 
+type tumblrMeta struct {
+	Meta struct {
+		Status int    `json:"status"`
+		Msg    string `json:"msg"`
+	} `json:"meta"`
+}
+
+func (m tumblrMeta) err(tumblrID string) error {
+	detail := fmt.Sprintf("blog=%s status=%d %s", tumblrID, m.Meta.Status, m.Meta.Msg)
+	if m.Meta.Status == http.StatusNotFound {
+		return upstreamError("tumblr", repository.ErrorBlogNotFound, detail)
+	}
+	if err := statusToError(m.Meta.Status); err != nil {
+		return upstreamError("tumblr", err, detail)
+	}
+	return fmt.Errorf("tumblr: %s", detail)
+}
+
+type tumblrMedia struct {
+	Url    string `json:"url"`
+	Type   string `json:"type"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// https://www.tumblr.com/docs/npf
+type tumblrContentBlock struct {
+	Type  string        `json:"type"`
+	Text  string        `json:"text"`
+	Media []tumblrMedia `json:"media"`
+}
+
+type tumblrPost struct {
+	ID        string               `json:"id_string"`
+	Timestamp int64                `json:"timestamp"`
+	BlogName  string               `json:"blog_name"`
+	PostURL   string               `json:"post_url"`
+	Summary   string               `json:"summary"`
+	Tags      []string             `json:"tags"`
+	Content   []tumblrContentBlock `json:"content"`
+	Trail     []struct {
+		Content []tumblrContentBlock `json:"content"`
+	} `json:"trail"`
+	// npf=true でなかったときの旧形式
+	Photos []struct {
+		OriginalSize tumblrMedia   `json:"original_size"`
+		AltSizes     []tumblrMedia `json:"alt_sizes"`
+	} `json:"photos"`
+}
+
+func (p *tumblrPost) indexed() tumblrIndexedPost {
+	return tumblrIndexedPost{
+		ID:        p.ID,
+		Timestamp: p.Timestamp,
+		Tags:      p.Tags,
+		Images:    p.images(),
+	}
+}
+
+func (p *tumblrPost) blocks() []tumblrContentBlock {
+	blocks := append([]tumblrContentBlock{}, p.Content...)
+	for _, t := range p.Trail {
+		blocks = append(blocks, t.Content...)
+	}
+	return blocks
+}
+
+func (p *tumblrPost) caption() string {
+	texts := []string{}
+	for _, b := range p.blocks() {
+		if b.Type == "text" && b.Text != "" {
+			texts = append(texts, b.Text)
+		}
+	}
+	caption := strings.Join(texts, " ")
+	if caption == "" {
+		caption = p.Summary
+	}
+	if r := []rune(caption); tumblrCaptionLimit < len(r) {
+		caption = string(r[:tumblrCaptionLimit]) + "…"
+	}
+	return caption
+}
+
+func (p *tumblrPost) images() []*domain.Image {
+	medias := []tumblrMedia{}
+	for _, b := range p.blocks() {
+		if b.Type == "image" && 0 < len(b.Media) {
+			medias = append(medias, pickTumblrMedia(b.Media))
+		}
+	}
+	for _, photo := range p.Photos {
+		medias = append(medias, pickTumblrMedia(append([]tumblrMedia{photo.OriginalSize}, photo.AltSizes...)))
+	}
+
+	caption := p.caption()
+	images := make([]*domain.Image, 0, len(medias))
+	for _, m := range medias {
+		images = append(images, &domain.Image{
+			URL:    m.Url,
+			Source: "tumblr",
+			Title:  caption,
+			Author: p.BlogName,
+			Link:   p.PostURL,
+			Width:  m.Width,
+			Height: m.Height,
+			Tags:   p.Tags,
+		})
+	}
+	return images
+}
+
+// GIF はアニメーションが消えないように GIF のまま選ぶ
+func pickTumblrMedia(medias []tumblrMedia) tumblrMedia {
+	best := medias[0]
+	for _, m := range medias {
+		if tumblrMaxWidth < m.Width {
+			continue
+		}
+		if best.Width <= tumblrMaxWidth && m.Width <= best.Width {
+			continue
+		}
+		if best.Type == "image/gif" && m.Type != "" && m.Type != best.Type {
+			continue
+		}
+		best = m
+	}
+	return best
+}
+
 type TumblrSearchResponse struct {
 	Response struct {
-		Posts []struct {
-			Photos []struct {
-				OriginalSize struct {
-					Url string `json:"url"`
-				} `json:"original_size"`
-			} `json:"photos"`
-		} `json:"posts"`
-		TotalPosts int `json:"total_posts"`
+		Posts      []tumblrPost `json:"posts"`
+		TotalPosts int          `json:"total_posts"`
 	} `json:"response"`
 }
 
-func (t *TumblrSearchResponse) RandomPhotoURL() string {
-	urls := make([]string, 0, tumblrPageLimit)
-	for _, post := range t.Response.Posts {
-		for _, photo := range post.Photos {
-			urls = append(urls, photo.OriginalSize.Url)
-		}
+func (t *TumblrSearchResponse) indexedPosts() []tumblrIndexedPost {
+	posts := make([]tumblrIndexedPost, 0, len(t.Response.Posts))
+	for _, p := range t.Response.Posts {
+		posts = append(posts, p.indexed())
 	}
-	if n := len(urls); 0 < n {
-		return urls[rand.Intn(n)]
-	}
-	return ""
+	return posts
 }
 
-type tumblrRandomSearchResponse struct {
-	photoURL string
+func randomPhoto(ctx context.Context, posts []tumblrIndexedPost, random repository.Random, scores repository.ScoreStore, safety repository.Safety) []*domain.Image {
+	images := []*domain.Image{}
+	for _, post := range posts {
+		images = append(images, safeImages(ctx, safety, post.Tags, post.Images)...)
+	}
+	if 0 < len(images) {
+		return []*domain.Image{weightedPick(random, scores, images)}
+	}
+	return nil
 }
 
-func (t *tumblrRandomSearchResponse) PhotoURL() string {
-	return t.photoURL
+func randomPost(ctx context.Context, posts []tumblrIndexedPost, random repository.Random, safety repository.Safety) []*domain.Image {
+	sets := [][]*domain.Image{}
+	for _, post := range posts {
+		if images := safeImages(ctx, safety, post.Tags, post.Images); 0 < len(images) {
+			sets = append(sets, images)
+		}
+	}
+	if 0 < len(sets) {
+		return sets[random.Intn(len(sets))]
+	}
+	return nil
 }
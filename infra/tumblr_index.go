@@ -0,0 +1,260 @@
+package infra
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mix3/iyashi-bot/domain"
+)
+
+const (
+	tumblrCrawlWait = time.Second
+)
+
+type tumblrIndexedPost struct {
+	ID        string          `json:"id"`
+	Timestamp int64           `json:"timestamp"`
+	Tags      []string        `json:"tags"`
+	Images    []*domain.Image `json:"images"`
+}
+
+// ブログの写真投稿を新しい順に持っておく
+type tumblrIndex struct {
+	mu    sync.RWMutex
+	posts []tumblrIndexedPost
+
+	// クロールの進み具合。クロールする goroutine からしか触らない
+	before   int64
+	complete bool
+}
+
+func (idx *tumblrIndex) snapshot() []tumblrIndexedPost {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.posts
+}
+
+func (idx *tumblrIndex) replace(posts []tumblrIndexedPost) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.posts = posts
+}
+
+// タグをすべて含む投稿だけに絞る
+func (idx *tumblrIndex) filter(tags []string) []tumblrIndexedPost {
+	posts := []tumblrIndexedPost{}
+	for _, post := range idx.snapshot() {
+		if 0 < len(post.Images) && hasAllTags(post.Tags, tags) {
+			posts = append(posts, post)
+		}
+	}
+	return posts
+}
+
+func hasAllTags(postTags, tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, pt := range postTags {
+			if strings.EqualFold(pt, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type tumblrIndexer struct {
+	searcher *tumblrSearcher
+	dir      string
+	interval time.Duration
+
+	mu      sync.Mutex
+	indexes map[string]*tumblrIndex
+	ready   map[string]bool
+}
+
+func newTumblrIndexer(searcher *tumblrSearcher, dir string, interval time.Duration) *tumblrIndexer {
+	return &tumblrIndexer{
+		searcher: searcher,
+		dir:      dir,
+		interval: interval,
+		indexes:  map[string]*tumblrIndex{},
+		ready:    map[string]bool{},
+	}
+}
+
+func (i *tumblrIndexer) path(tumblrID string) string {
+	if i.dir == "" {
+		return ""
+	}
+	return filepath.Join(i.dir, tumblrID+".json")
+}
+
+// 保存しているインデックス。before は遡る途中の位置、complete は最後まで遡り終えたかどうか
+type tumblrIndexFile struct {
+	Posts    []tumblrIndexedPost `json:"posts"`
+	Before   int64               `json:"before,omitempty"`
+	Complete bool                `json:"complete"`
+}
+
+// interval が 0 か保存先が無ければインデックスを作らずに毎回 API を叩く。
+// 保存先が無いと起動のたびに全件を読み直して API の上限を使い切ってしまう
+func (i *tumblrIndexer) watch(tumblrID string) {
+	if i.interval <= 0 || i.dir == "" {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.indexes[tumblrID]; ok {
+		return
+	}
+	idx := &tumblrIndex{}
+	var file tumblrIndexFile
+	if err := loadJSONFile(i.path(tumblrID), &file); err != nil {
+		log.Printf("[WARN] tumblr index load blog=%s err:%s", tumblrID, err)
+	} else {
+		idx.replace(file.Posts)
+		idx.before = file.Before
+		idx.complete = file.Complete
+		i.ready[tumblrID] = file.Complete
+	}
+	i.indexes[tumblrID] = idx
+	go i.run(tumblrID, idx)
+}
+
+func (i *tumblrIndexer) index(tumblrID string) (*tumblrIndex, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.ready[tumblrID] {
+		return nil, false
+	}
+	return i.indexes[tumblrID], true
+}
+
+func (i *tumblrIndexer) run(tumblrID string, idx *tumblrIndex) {
+	for {
+		if err := i.crawl(context.Background(), tumblrID, idx); err != nil {
+			log.Printf("[WARN] tumblr index crawl blog=%s err:%s", tumblrID, err)
+		}
+		if idx.complete {
+			i.mu.Lock()
+			i.ready[tumblrID] = true
+			i.mu.Unlock()
+		}
+		time.Sleep(i.interval)
+	}
+}
+
+// 新着を読んでから、まだ遡り終えていなければ続きから古い方へ読む
+func (i *tumblrIndexer) crawl(ctx context.Context, tumblrID string, idx *tumblrIndex) error {
+	if 0 < len(idx.snapshot()) || idx.complete {
+		if err := i.crawlNewer(ctx, tumblrID, idx); err != nil {
+			return err
+		}
+	}
+	if idx.complete {
+		return nil
+	}
+	return i.crawlOlder(ctx, tumblrID, idx)
+}
+
+// 新しい投稿から順に読んで、既に知っている投稿に当たったら止める。
+// 途中で失敗すると間が抜けるので、新着分は最後まで読めたときだけ反映する
+func (i *tumblrIndexer) crawlNewer(ctx context.Context, tumblrID string, idx *tumblrIndex) error {
+	current := idx.snapshot()
+	known := make(map[string]bool, len(current))
+	for _, p := range current {
+		known[p.ID] = true
+	}
+
+	fresh := []tumblrIndexedPost{}
+	var before int64
+	for {
+		res, err := i.searcher.search(ctx, tumblrID, nil, 0, before)
+		if err != nil {
+			return err
+		}
+		posts := res.Response.Posts
+		reachedKnown := false
+		for _, p := range posts {
+			if known[p.ID] {
+				reachedKnown = true
+				break
+			}
+			known[p.ID] = true
+			if post := p.indexed(); 0 < len(post.Images) {
+				fresh = append(fresh, post)
+			}
+		}
+		if reachedKnown || len(posts) < tumblrPageLimit {
+			break
+		}
+		before = posts[len(posts)-1].Timestamp
+		time.Sleep(tumblrCrawlWait)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	posts := append(fresh, current...)
+	idx.replace(posts)
+	log.Printf("[INFO] tumblr index blog=%s new=%d total=%d", tumblrID, len(fresh), len(posts))
+	return i.save(tumblrID, idx)
+}
+
+// before より前を読む。1 ページごとに保存するので、失敗しても次は続きから読める
+func (i *tumblrIndexer) crawlOlder(ctx context.Context, tumblrID string, idx *tumblrIndex) error {
+	for {
+		current := idx.snapshot()
+		known := make(map[string]bool, len(current))
+		for _, p := range current {
+			known[p.ID] = true
+		}
+
+		res, err := i.searcher.search(ctx, tumblrID, nil, 0, idx.before)
+		if err != nil {
+			return err
+		}
+		posts := res.Response.Posts
+		older := []tumblrIndexedPost{}
+		for _, p := range posts {
+			if known[p.ID] {
+				continue
+			}
+			if post := p.indexed(); 0 < len(post.Images) {
+				older = append(older, post)
+			}
+		}
+		idx.replace(append(append([]tumblrIndexedPost{}, current...), older...))
+		if len(posts) < tumblrPageLimit {
+			idx.complete = true
+		} else {
+			// 写真の無い投稿しか無いページでも先へ進めるように、読んだ投稿の時刻で進める
+			idx.before = posts[len(posts)-1].Timestamp
+		}
+		if err := i.save(tumblrID, idx); err != nil {
+			return err
+		}
+		if idx.complete {
+			log.Printf("[INFO] tumblr index blog=%s complete total=%d", tumblrID, len(idx.snapshot()))
+			return nil
+		}
+		time.Sleep(tumblrCrawlWait)
+	}
+}
+
+func (i *tumblrIndexer) save(tumblrID string, idx *tumblrIndex) error {
+	return saveJSONFile(i.path(tumblrID), tumblrIndexFile{
+		Posts:    idx.snapshot(),
+		Before:   idx.before,
+		Complete: idx.complete,
+	})
+}
@@ -0,0 +1,166 @@
+package infra
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	urlSigningHMAC = "hmac"
+	urlSigningS3   = "s3"
+
+	// SigV4 の presign は 7 日が上限
+	s3PresignMaxExpiry = 7 * 24 * time.Hour
+)
+
+// origin を非公開にしたまま、Slack に貼ったリンクだけ一定時間見られるようにする
+type urlSigner interface {
+	sign(rawURL string, now time.Time) (string, error)
+}
+
+func newURLSigner(scheme, secret, accessKeyID, region string, expiry time.Duration) (urlSigner, error) {
+	switch scheme {
+	case "":
+		return nil, nil
+	case urlSigningHMAC:
+		return &hmacSigner{secret: []byte(secret), expiry: expiry}, nil
+	case urlSigningS3:
+		if s3PresignMaxExpiry < expiry {
+			expiry = s3PresignMaxExpiry
+		}
+		return &s3Presigner{accessKeyID: accessKeyID, secret: secret, region: region, expiry: expiry}, nil
+	}
+	return nil, fmt.Errorf("unknown url signing scheme: %s", scheme)
+}
+
+// ?expires=<unix>&signature=<base64url(HMAC-SHA256(secret, path + "?expires=" + expires))>
+// CDN のエッジや origin 側の小さなプロキシで検証する前提の単純な方式
+type hmacSigner struct {
+	secret []byte
+	expiry time.Duration
+}
+
+func (s *hmacSigner) sign(rawURL string, now time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(now.Add(s.expiry).Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(u.EscapedPath() + "?expires=" + expires))
+
+	q := u.Query()
+	q.Set("expires", expires)
+	q.Set("signature", base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+type s3Presigner struct {
+	accessKeyID string
+	secret      string
+	region      string
+	expiry      time.Duration
+}
+
+func (s *s3Presigner) sign(rawURL string, now time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.accessKeyID+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(s.expiry/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalURI := s3CanonicalURI(u.Path)
+	canonicalQuery := s3CanonicalQuery(q)
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		canonicalURI,
+		canonicalQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secret), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	u.RawPath = canonicalURI
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func s3CanonicalURI(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = awsEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		values := append([]string{}, q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// url.QueryEscape は空白を + にするので SigV4 の規則どおりに自前でやる
+func awsEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
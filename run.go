@@ -1,11 +1,8 @@
 package iyashibot
 
 import (
-	crand "crypto/rand"
+	"context"
 	"log"
-	"math"
-	"math/big"
-	"math/rand"
 	"net/http"
 	"os"
 
@@ -23,12 +20,6 @@ func init() {
 		MinLevel: logutils.LogLevel("INFO"),
 		Writer:   os.Stderr,
 	})
-
-	seed, err := crand.Int(crand.Reader, big.NewInt(math.MaxInt64))
-	if err != nil {
-		panic(err)
-	}
-	rand.Seed(seed.Int64())
 }
 
 func Run(opts ...config.Option) error {
@@ -45,11 +36,15 @@ func Run(opts ...config.Option) error {
 		return err
 	}
 
-	uc := usecase.NewUsecase(repo)
+	uc := usecase.NewUsecase(conf, repo)
 	h := handler.NewHandler(conf, uc)
 
+	go uc.ScheduleDaily(context.Background())
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", h.Index)
+	mux.HandleFunc("/stats", h.Stats)
+	mux.HandleFunc("/daily", h.Daily)
 	log.Println("[INFO] Server listening")
 	ridge.Run(":8080", "/", mux)
 	return nil
@@ -2,9 +2,15 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math/rand"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/mix3/iyashi-bot/domain"
 	"github.com/mix3/iyashi-bot/domain/repository"
 )
 
@@ -16,13 +22,13 @@ type Command interface {
 }
 
 type helpCommand struct {
-	slackAPI repository.SlackAPI
+	poster   *poster
 	commands []Command
 }
 
-func newHelpCommand(slackAPI repository.SlackAPI, commands []Command) Command {
+func newHelpCommand(poster *poster, commands []Command) Command {
 	return &helpCommand{
-		slackAPI: slackAPI,
+		poster:   poster,
 		commands: commands,
 	}
 }
@@ -52,22 +58,24 @@ func (h *helpCommand) Execute(ctx context.Context, channel, user string, args []
 	if 0 < len(args) {
 		for _, c := range h.commands {
 			if c.Match(args[0]) {
-				return h.slackAPI.Reply(ctx, channel, user, c.Help())
+				return h.poster.reply(ctx, channel, user, c.Help())
 			}
 		}
 	}
-	return h.slackAPI.Reply(ctx, channel, user, h.Help())
+	return h.poster.reply(ctx, channel, user, h.Help())
 }
 
 type moeCommand struct {
-	slackAPI    repository.SlackAPI
+	poster      *poster
 	moeSearcher repository.MoeSearcher
+	upload      bool
 }
 
-func newMoeCommand(repo repository.Repository) Command {
+func newMoeCommand(repo repository.Repository, upload bool) Command {
 	return &moeCommand{
-		slackAPI:    repo.SlackAPI(),
+		poster:      newPoster(repo),
 		moeSearcher: repo.MoeSearcher(),
+		upload:      upload,
 	}
 }
 
@@ -85,31 +93,50 @@ func (m *moeCommand) Match(str string) bool {
 }
 
 func (m *moeCommand) Help() string {
-	return "mix3 が溜め込んだ画像を返すよ！"
+	return "mix3 が溜め込んだ画像を返すよ！ もえ 猫 のようにタグで絞れるよ (--upload でファイルとして送るよ)"
 }
 
 func (m *moeCommand) Execute(ctx context.Context, channel, user string, args []string) error {
-	res, err := m.moeSearcher.RandomSearch(ctx)
+	args, f := parseFlags(args)
+	if m.upload || f.Has("upload") {
+		ctx = withUpload(ctx)
+	}
+	res, err := m.moeSearcher.RandomSearch(ctx, args)
 	if err != nil {
+		if errors.Is(err, repository.ErrorNotFound) && 0 < len(args) {
+			return m.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		}
 		return err
 	}
-	return m.slackAPI.Reply(ctx, channel, user, res.ImageURL())
+	return m.poster.replyImage(ctx, channel, user, res, strings.Join(args, " "))
 }
 
 type iyashiCommand struct {
-	slackAPI       repository.SlackAPI
-	flickrSearcher repository.FlickrSearcher
+	poster           *poster
+	flickrSearcher   repository.FlickrSearcher
+	mastodonSearcher repository.MastodonSearcher
+	dictionary       repository.Dictionary
+	matchStrings     []string
+	help             string
+	preset           repository.FlickrQuery
+	hashtags         []string
 }
 
-func newIyashiCommand(repo repository.Repository) Command {
+func newIyashiCommand(repo repository.Repository, matchStrings []string, help string, preset repository.FlickrQuery, hashtags []string) Command {
 	return &iyashiCommand{
-		slackAPI:       repo.SlackAPI(),
-		flickrSearcher: repo.FlickrSearcher(),
+		poster:           newPoster(repo),
+		flickrSearcher:   repo.FlickrSearcher(),
+		mastodonSearcher: repo.MastodonSearcher(),
+		dictionary:       repo.Dictionary(),
+		matchStrings:     matchStrings,
+		help:             help,
+		preset:           preset,
+		hashtags:         hashtags,
 	}
 }
 
 func (m iyashiCommand) MatchStrings() []string {
-	return []string{"癒やし", "癒し"}
+	return m.matchStrings
 }
 
 func (m *iyashiCommand) Match(str string) bool {
@@ -122,26 +149,321 @@ func (m *iyashiCommand) Match(str string) bool {
 }
 
 func (m *iyashiCommand) Help() string {
-	return "flicker から画像を返すよ！"
+	return m.help + " (--size=z|b|h --orientation=landscape|portrait|square --min-width=N --min-height=N --sort=interesting --tags=a,b --tag-mode=all|any --group=ID --user=ID --geo --radius=km --upload --debug --source=mastodon が使えるよ)"
 }
 
 func (m *iyashiCommand) Execute(ctx context.Context, channel, user string, args []string) error {
-	res, err := m.flickrSearcher.RandomSearch(ctx, args)
+	args, f := parseFlags(args)
+	if name, ok := f.missingValue(); ok {
+		return m.poster.reply(ctx, channel, user, fmt.Sprintf("--%s には値を指定してね (例: --%s=値)", name, name))
+	}
+	query, err := applyFlickrFlags(m.preset, f)
+	if err != nil {
+		return m.poster.reply(ctx, channel, user, err.Error())
+	}
+	if f.Has("upload") {
+		ctx = withUpload(ctx)
+	}
+	switch source := f.String("source", "flickr"); source {
+	case "flickr":
+	case "mastodon":
+		return m.executeMastodon(ctx, channel, user, args, f)
+	default:
+		return m.poster.reply(ctx, channel, user, "--source は flickr か mastodon を指定してね")
+	}
+	// --geo なら最初の単語を地名として扱う
+	if f.Has("geo") && 0 < len(args) {
+		query.Place = args[0]
+		args = args[1:]
+	}
+	query.Keywords = append(append([]string{}, m.preset.Keywords...), expandKeywords(m.dictionary, args)...)
+	query.Tags = translateTags(m.dictionary, query.Tags)
+	if f.Has("debug") {
+		q := query.Keywords
+		if query.Place != "" {
+			q = append([]string{"place:" + query.Place}, q...)
+		}
+		if err := m.poster.reply(ctx, channel, user, debugQuery(q)); err != nil {
+			return err
+		}
+	}
+	res, err := m.flickrSearcher.RandomSearch(ctx, query)
+	if err != nil {
+		if errors.Is(err, repository.ErrorNotFound) {
+			return m.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		}
+		return err
+	}
+	if err := m.poster.directMessageImage(ctx, user, res, strings.Join(args, " ")); err != nil {
+		return err
+	}
+	return m.poster.reply(ctx, channel, user, "╭( ･ㅂ･)ﻭ ̑̑ DMしたよ")
+}
+
+// 単語はハッシュタグとして扱う。英語のタグに寄せたほうが見つかりやすい
+func (m *iyashiCommand) executeMastodon(ctx context.Context, channel, user string, args []string, f flags) error {
+	hashtags := m.hashtags
+	if 0 < len(args) {
+		hashtags = m.dictionary.Translate(args[0])
+	}
+	if f.Has("debug") {
+		if err := m.poster.reply(ctx, channel, user, debugQuery(hashtags)); err != nil {
+			return err
+		}
+	}
+	res, err := m.mastodonSearcher.RandomSearch(ctx, hashtags)
+	if err != nil {
+		if errors.Is(err, repository.ErrorNotFound) {
+			return m.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		}
+		return err
+	}
+	if err := m.poster.directMessageImage(ctx, user, res, strings.Join(args, " ")); err != nil {
+		return err
+	}
+	return m.poster.reply(ctx, channel, user, "╭( ･ㅂ･)ﻭ ̑̑ DMしたよ")
+}
+
+type gifCommand struct {
+	poster      *poster
+	gifSearcher repository.GifSearcher
+	dictionary  repository.Dictionary
+}
+
+func newGifCommand(repo repository.Repository) Command {
+	return &gifCommand{
+		poster:      newPoster(repo),
+		gifSearcher: repo.GifSearcher(),
+		dictionary:  repo.Dictionary(),
+	}
+}
+
+func (c *gifCommand) MatchStrings() []string {
+	return []string{"癒しgif", "癒やしgif"}
+}
+
+func (c *gifCommand) Match(str string) bool {
+	for _, s := range c.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *gifCommand) Help() string {
+	return "動く癒やし画像 (GIF) を返すよ！ (--upload --debug が使えるよ)"
+}
+
+// GIF の検索は英語のほうが圧倒的に当たるので辞書で寄せる
+func (c *gifCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	args, f := parseFlags(args)
+	if f.Has("upload") {
+		ctx = withUpload(ctx)
+	}
+	keywords := translateTags(c.dictionary, args)
+	if f.Has("debug") {
+		if err := c.poster.reply(ctx, channel, user, debugQuery(keywords)); err != nil {
+			return err
+		}
+	}
+	res, err := c.gifSearcher.RandomSearch(ctx, keywords)
+	if err != nil {
+		if errors.Is(err, repository.ErrorNotFound) {
+			return c.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		}
+		return err
+	}
+	if err := c.poster.directMessageImage(ctx, user, res, strings.Join(args, " ")); err != nil {
+		return err
+	}
+	return c.poster.reply(ctx, channel, user, "╭( ･ㅂ･)ﻭ ̑̑ DMしたよ")
+}
+
+type commonsCommand struct {
+	poster          *poster
+	commonsSearcher repository.CommonsSearcher
+	dictionary      repository.Dictionary
+}
+
+func newCommonsCommand(repo repository.Repository) Command {
+	return &commonsCommand{
+		poster:          newPoster(repo),
+		commonsSearcher: repo.CommonsSearcher(),
+		dictionary:      repo.Dictionary(),
+	}
+}
+
+func (c *commonsCommand) MatchStrings() []string {
+	return []string{"どうぶつ", "動物"}
+}
+
+func (c *commonsCommand) Match(str string) bool {
+	for _, s := range c.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *commonsCommand) Help() string {
+	return "Wikimedia Commons から自由なライセンスの動物の写真を返すよ！ (--upload --debug が使えるよ)"
+}
+
+// クレジットが必須なので、作者とライセンスが付いた画像しか返ってこない
+func (c *commonsCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	args, f := parseFlags(args)
+	if f.Has("upload") {
+		ctx = withUpload(ctx)
+	}
+	keywords := translateTags(c.dictionary, args)
+	if f.Has("debug") {
+		if err := c.poster.reply(ctx, channel, user, debugQuery(keywords)); err != nil {
+			return err
+		}
+	}
+	res, err := c.commonsSearcher.RandomSearch(ctx, keywords)
 	if err != nil {
-		if err == repository.ErrorNotFound {
-			return m.slackAPI.Reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		if errors.Is(err, repository.ErrorNotFound) {
+			return c.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
 		}
 		return err
 	}
-	if err := m.slackAPI.DirectMessage(ctx, user, res.ImageURL()); err != nil {
+	return c.poster.replyImage(ctx, channel, user, res, strings.Join(args, " "))
+}
+
+type booruCommand struct {
+	poster         *poster
+	booruSearcher  repository.BooruSearcher
+	dictionary     repository.Dictionary
+	channelRatings map[string]repository.BooruRating
+}
+
+func newBooruCommand(repo repository.Repository, channelRatings map[string]string) Command {
+	ratings := map[string]repository.BooruRating{}
+	for ch, r := range channelRatings {
+		if rating, ok := repository.ParseBooruRating(r); ok {
+			ratings[ch] = rating
+		}
+	}
+	return &booruCommand{
+		poster:         newPoster(repo),
+		booruSearcher:  repo.BooruSearcher(),
+		dictionary:     repo.Dictionary(),
+		channelRatings: ratings,
+	}
+}
+
+func (c *booruCommand) MatchStrings() []string {
+	return []string{"いらすと", "イラスト"}
+}
+
+func (c *booruCommand) Match(str string) bool {
+	for _, s := range c.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *booruCommand) Help() string {
+	return "タグを指定してイラストを返すよ！ (--upload --debug が使えるよ)"
+}
+
+// 許可したチャンネル以外では rating は general から動かさない
+func (c *booruCommand) rating(channel string) repository.BooruRating {
+	if r, ok := c.channelRatings[channel]; ok {
+		return r
+	}
+	return repository.BooruRatingGeneral
+}
+
+func (c *booruCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	args, f := parseFlags(args)
+	if f.Has("upload") {
+		ctx = withUpload(ctx)
+	}
+	// booru のタグは英語で空白の代わりに _ を使う
+	tags := translateTags(c.dictionary, args)
+	for i, t := range tags {
+		tags[i] = strings.ReplaceAll(strings.ToLower(t), " ", "_")
+	}
+	query := repository.BooruQuery{Tags: tags, Rating: c.rating(channel)}
+	if f.Has("debug") {
+		if err := c.poster.reply(ctx, channel, user, debugQuery(append(append([]string{}, tags...), "rating:"+query.Rating.String()))); err != nil {
+			return err
+		}
+	}
+	res, err := c.booruSearcher.RandomSearch(ctx, query)
+	if err != nil {
+		if errors.Is(err, repository.ErrorNotFound) {
+			return c.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		}
 		return err
 	}
-	return m.slackAPI.Reply(ctx, channel, user, "╭( ･ㅂ･)ﻭ ̑̑ DMしたよ")
+	return c.poster.replyImage(ctx, channel, user, res, strings.Join(args, " "))
+}
+
+type emojiCommand struct {
+	poster   *poster
+	slackAPI repository.SlackAPI
+	random   repository.Random
+}
+
+func newEmojiCommand(repo repository.Repository) Command {
+	return &emojiCommand{
+		poster:   newPoster(repo),
+		slackAPI: repo.SlackAPI(),
+		random:   repo.Random(),
+	}
+}
+
+func (c *emojiCommand) MatchStrings() []string {
+	return []string{"絵文字", "えもじ"}
+}
+
+func (c *emojiCommand) Match(str string) bool {
+	for _, s := range c.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *emojiCommand) Help() string {
+	return "ワークスペースの絵文字をランダムに返すよ！ (名前の一部を指定できるよ)"
+}
+
+func (c *emojiCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	all, err := c.slackAPI.ListEmoji(ctx)
+	if err != nil {
+		return err
+	}
+	word := strings.ToLower(strings.Join(args, "_"))
+	emoji := make([]repository.Emoji, 0, len(all))
+	for _, e := range all {
+		if strings.Contains(e.Name, word) {
+			emoji = append(emoji, e)
+		}
+	}
+	if len(emoji) == 0 {
+		return c.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+	}
+	random, ok := repository.RandomFromContext(ctx)
+	if !ok {
+		random = c.random
+	}
+	e := emoji[random.Intn(len(emoji))]
+	return c.poster.replyImageBlock(ctx, channel, user, fmt.Sprintf(":%s:", e.Name), e.URL, word)
 }
 
 type tumblrCommand struct {
-	slackAPI       repository.SlackAPI
+	poster         *poster
 	tumblrSearcher repository.TumblrSearcher
+	dictionary     repository.Dictionary
 	tumblrID       string
 	matchStrings   []string
 	appendTags     []string
@@ -149,9 +471,11 @@ type tumblrCommand struct {
 }
 
 func newTumblrCommand(repo repository.Repository, tumblrID string, matchStrings, appendTags []string, isDM bool) Command {
+	repo.TumblrSearcher().Watch(tumblrID)
 	return &tumblrCommand{
-		slackAPI:       repo.SlackAPI(),
+		poster:         newPoster(repo),
 		tumblrSearcher: repo.TumblrSearcher(),
+		dictionary:     repo.Dictionary(),
 		tumblrID:       tumblrID,
 		matchStrings:   matchStrings,
 		appendTags:     appendTags,
@@ -173,23 +497,441 @@ func (t *tumblrCommand) Match(str string) bool {
 }
 
 func (t *tumblrCommand) Help() string {
-	return fmt.Sprintf("http://%s.tumblr.com/ から画像をランダムで返すよ！", t.tumblrID)
+	return fmt.Sprintf("http://%s.tumblr.com/ から画像をランダムで返すよ！ --all で投稿の画像をまとめて、--upload でファイルとして返すよ", t.tumblrID)
+}
+
+func (t *tumblrCommand) query(tags []string) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", t.tumblrID, strings.Join(tags, " ")))
 }
 
 func (t *tumblrCommand) Execute(ctx context.Context, channel, user string, args []string) error {
-	res, err := t.tumblrSearcher.RandomSearch(ctx, t.tumblrID, append(args, t.appendTags...))
+	args, f := parseFlags(args)
+	tags := append(translateTags(t.dictionary, args), t.appendTags...)
+	if f.Has("upload") {
+		ctx = withUpload(ctx)
+	}
+	if f.Has("debug") {
+		if err := t.poster.reply(ctx, channel, user, debugQuery(tags)); err != nil {
+			return err
+		}
+	}
+	var res []*domain.Image
+	var err error
+	if f.Has("all") {
+		res, err = t.tumblrSearcher.RandomPhotoset(ctx, t.tumblrID, tags)
+	} else {
+		var image *domain.Image
+		image, err = t.tumblrSearcher.RandomSearch(ctx, t.tumblrID, tags)
+		res = []*domain.Image{image}
+	}
 	if err != nil {
-		if err == repository.ErrorNotFound {
-			return t.slackAPI.Reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		if errors.Is(err, repository.ErrorNotFound) {
+			return t.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
 		}
 		return err
 	}
 	if t.isDM {
-		if err := t.slackAPI.DirectMessage(ctx, user, res.PhotoURL()); err != nil {
+		if err := t.poster.directMessageImages(ctx, user, res, t.query(tags)); err != nil {
 			return err
 		}
-		return t.slackAPI.Reply(ctx, channel, user, "╭( ･ㅂ･)ﻭ ̑̑ DMしたよ")
+		return t.poster.reply(ctx, channel, user, "╭( ･ㅂ･)ﻭ ̑̑ DMしたよ")
 	} else {
-		return t.slackAPI.Reply(ctx, channel, user, res.PhotoURL())
+		return t.poster.replyImages(ctx, channel, user, res, t.query(tags))
+	}
+}
+
+type feedCommand struct {
+	poster       *poster
+	feedSearcher repository.FeedSearcher
+	dictionary   repository.Dictionary
+	feedURL      string
+	matchStrings []string
+	help         string
+	appendTags   []string
+	isDM         bool
+}
+
+func newFeedCommand(repo repository.Repository, feedURL string, matchStrings []string, help string, appendTags []string, isDM bool) Command {
+	return &feedCommand{
+		poster:       newPoster(repo),
+		feedSearcher: repo.FeedSearcher(),
+		dictionary:   repo.Dictionary(),
+		feedURL:      feedURL,
+		matchStrings: matchStrings,
+		help:         help,
+		appendTags:   appendTags,
+		isDM:         isDM,
+	}
+}
+
+func (c *feedCommand) MatchStrings() []string {
+	return c.matchStrings
+}
+
+func (c *feedCommand) Match(str string) bool {
+	for _, s := range c.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *feedCommand) Help() string {
+	if c.help != "" {
+		return c.help
+	}
+	return fmt.Sprintf("%s から画像をランダムで返すよ！", c.feedURL)
+}
+
+func (c *feedCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	args, f := parseFlags(args)
+	tags := append(translateTags(c.dictionary, args), c.appendTags...)
+	if f.Has("upload") {
+		ctx = withUpload(ctx)
+	}
+	if f.Has("debug") {
+		if err := c.poster.reply(ctx, channel, user, debugQuery(tags)); err != nil {
+			return err
+		}
+	}
+	res, err := c.feedSearcher.RandomSearch(ctx, c.feedURL, tags)
+	if err != nil {
+		if errors.Is(err, repository.ErrorNotFound) {
+			return c.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		}
+		return err
+	}
+	query := strings.TrimSpace(fmt.Sprintf("%s %s", c.feedURL, strings.Join(tags, " ")))
+	if c.isDM {
+		if err := c.poster.directMessageImage(ctx, user, res, query); err != nil {
+			return err
+		}
+		return c.poster.reply(ctx, channel, user, "╭( ･ㅂ･)ﻭ ̑̑ DMしたよ")
+	}
+	return c.poster.replyImage(ctx, channel, user, res, query)
+}
+
+type redditCommand struct {
+	poster         *poster
+	redditSearcher repository.RedditSearcher
+	subreddit      string
+	sort           string
+	matchStrings   []string
+	help           string
+	isDM           bool
+}
+
+func newRedditCommand(repo repository.Repository, subreddit, sort string, matchStrings []string, help string, isDM bool) Command {
+	return &redditCommand{
+		poster:         newPoster(repo),
+		redditSearcher: repo.RedditSearcher(),
+		subreddit:      subreddit,
+		sort:           sort,
+		matchStrings:   matchStrings,
+		help:           help,
+		isDM:           isDM,
+	}
+}
+
+func (c *redditCommand) MatchStrings() []string {
+	return c.matchStrings
+}
+
+func (c *redditCommand) Match(str string) bool {
+	for _, s := range c.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *redditCommand) Help() string {
+	if c.help != "" {
+		return c.help
+	}
+	return fmt.Sprintf("https://www.reddit.com/r/%s/ から画像をランダムで返すよ！", c.subreddit)
+}
+
+func (c *redditCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	_, f := parseFlags(args)
+	if f.Has("upload") {
+		ctx = withUpload(ctx)
+	}
+	res, err := c.redditSearcher.RandomSearch(ctx, c.subreddit, c.sort)
+	if err != nil {
+		if errors.Is(err, repository.ErrorNotFound) {
+			return c.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		}
+		return err
+	}
+	query := "r/" + c.subreddit
+	if c.isDM {
+		if err := c.poster.directMessageImage(ctx, user, res, query); err != nil {
+			return err
+		}
+		return c.poster.reply(ctx, channel, user, "╭( ･ㅂ･)ﻭ ̑̑ DMしたよ")
+	}
+	return c.poster.replyImage(ctx, channel, user, res, query)
+}
+
+type dailyCommand struct {
+	poster         *poster
+	flickrSearcher repository.FlickrSearcher
+	store          repository.DailyStore
+	location       *time.Location
+
+	mu sync.Mutex
+}
+
+func newDailyCommand(repo repository.Repository, location *time.Location) *dailyCommand {
+	return &dailyCommand{
+		poster:         newPoster(repo),
+		flickrSearcher: repo.FlickrSearcher(),
+		store:          repo.DailyStore(),
+		location:       location,
+	}
+}
+
+func (d *dailyCommand) MatchStrings() []string {
+	return []string{"今日の癒し", "今日の癒やし"}
+}
+
+func (d *dailyCommand) Match(str string) bool {
+	for _, s := range d.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dailyCommand) Help() string {
+	return "今日の癒し画像を返すよ！みんな同じ画像だよ"
+}
+
+func (d *dailyCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	image, err := d.pick(ctx)
+	if err != nil {
+		if errors.Is(err, repository.ErrorNotFound) {
+			return d.poster.reply(ctx, channel, user, "見つかんなかったよ(´・ω・｀)")
+		}
+		return err
+	}
+	return d.poster.replyImage(ctx, channel, user, image, "")
+}
+
+// 外部のスケジュールから再送されても 1 日 1 回だけ投稿する
+func (d *dailyCommand) Post(ctx context.Context, channel string) error {
+	image, err := d.pick(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pick, _ := d.store.Get()
+	if pick.Posted {
+		return nil
+	}
+	if err := d.poster.postImage(ctx, channel, "今日の癒しだよ！", image, ""); err != nil {
+		return err
+	}
+	pick.Posted = true
+	return d.store.Save(pick)
+}
+
+// 選んだ画像は保存しておき、再起動や別のインスタンスでも同じ画像を返す。
+// どのチャンネルにも出せるように一番厳しい safe で選ぶ
+func (d *dailyCommand) pick(ctx context.Context) (*domain.Image, error) {
+	day := time.Now().In(d.location).Format("2006-01-02")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if pick, ok := d.store.Get(); ok && pick.Day == day {
+		return pick.Image, nil
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(day))
+	ctx = repository.WithRandom(ctx, rand.New(rand.NewSource(int64(h.Sum64()))))
+	ctx = repository.WithSafetyLevel(ctx, repository.SafetyLevelSafe)
+	image, err := d.flickrSearcher.RandomSearch(ctx, repository.FlickrQuery{})
+	if err != nil {
+		return nil, err
+	}
+	if err := d.store.Save(repository.DailyPick{Day: day, Image: image}); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+type hallOfFameCommand struct {
+	poster *poster
+	scores repository.ScoreStore
+	moe    repository.MoeSearcher
+}
+
+func newHallOfFameCommand(repo repository.Repository) Command {
+	return &hallOfFameCommand{
+		poster: newPoster(repo),
+		scores: repo.ScoreStore(),
+		moe:    repo.MoeSearcher(),
+	}
+}
+
+func (h *hallOfFameCommand) MatchStrings() []string {
+	return []string{"hall", "殿堂"}
+}
+
+func (h *hallOfFameCommand) Match(str string) bool {
+	for _, s := range h.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hallOfFameCommand) Help() string {
+	return "リアクションで評価の高かった画像を返すよ！(hall of fame)"
+}
+
+func (h *hallOfFameCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	top := h.scores.Top(10)
+	if len(top) == 0 {
+		return h.poster.reply(ctx, channel, user, "まだ評価された画像がないよ(´・ω・｀)")
+	}
+	lines := make([]string, 0, len(top))
+	for i, t := range top {
+		// スコアは署名前の URL で持っているので、貼る前に署名し直す
+		u, err := h.moe.SignURL(t.ImageURL)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%d. (+%d) %s", i+1, t.Score, u))
+	}
+	return h.poster.reply(ctx, channel, user, "殿堂入りだよ！\n"+strings.Join(lines, "\n"))
+}
+
+type deleteCommand struct {
+	poster   *poster
+	slackAPI repository.SlackAPI
+	postLog  repository.PostLog
+	admins   map[string]bool
+	rerun    func(ctx context.Context, channel, user string, args []string) error
+}
+
+func newDeleteCommand(repo repository.Repository, admins []string) *deleteCommand {
+	m := make(map[string]bool, len(admins))
+	for _, a := range admins {
+		m[a] = true
+	}
+	return &deleteCommand{
+		poster:   newPoster(repo),
+		slackAPI: repo.SlackAPI(),
+		postLog:  repo.PostLog(),
+		admins:   m,
+	}
+}
+
+func (d *deleteCommand) MatchStrings() []string {
+	return []string{"消して", "けして"}
+}
+
+func (d *deleteCommand) Match(str string) bool {
+	for _, s := range d.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *deleteCommand) Help() string {
+	return "最後に返した画像を消すよ！--reroll で別の画像を出し直すよ"
+}
+
+func (d *deleteCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	_, f := parseFlags(args)
+	post, ok := d.postLog.LastImage(channel, user)
+	if !ok && d.admins[user] {
+		post, ok = d.postLog.LastImage(channel, "")
+	}
+	if !ok {
+		return d.poster.reply(ctx, channel, user, "消せる画像が見つかんなかったよ(´・ω・｀)")
+	}
+	if err := d.delete(ctx, post); err != nil {
+		return err
+	}
+	if f.Has("reroll") && 0 < len(post.Args) && d.rerun != nil {
+		return d.rerun(ctx, channel, post.User, post.Args)
+	}
+	return nil
+}
+
+func (d *deleteCommand) allowed(post repository.Post, user string) bool {
+	return post.User == user || d.admins[user]
+}
+
+func (d *deleteCommand) delete(ctx context.Context, post repository.Post) error {
+	if err := d.slackAPI.DeleteMessage(ctx, post.Channel, post.TS); err != nil {
+		return err
+	}
+	// アップロードした画像はメッセージを消してもファイルが残る
+	if post.FileID != "" {
+		if err := d.slackAPI.DeleteFile(ctx, post.FileID); err != nil {
+			return err
+		}
+	}
+	return d.postLog.Remove(post.Channel, post.TS)
+}
+
+type reportCommand struct {
+	poster  *poster
+	postLog repository.PostLog
+	safety  repository.Safety
+}
+
+func newReportCommand(repo repository.Repository) Command {
+	return &reportCommand{
+		poster:  newPoster(repo),
+		postLog: repo.PostLog(),
+		safety:  repo.Safety(),
+	}
+}
+
+func (r *reportCommand) MatchStrings() []string {
+	return []string{"report", "通報"}
+}
+
+func (r *reportCommand) Match(str string) bool {
+	for _, s := range r.MatchStrings() {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *reportCommand) Help() string {
+	return "このチャンネルで最後に返した (DM したものも含む) 画像を二度と出さないようにするよ"
+}
+
+func (r *reportCommand) Execute(ctx context.Context, channel, user string, args []string) error {
+	// 癒しの画像は DM で返すので、このチャンネルで頼まれたものも対象にする
+	post, ok := r.postLog.LastRequestedImage(channel)
+	if !ok {
+		return r.poster.reply(ctx, channel, user, "通報できる画像が見つかんなかったよ(´・ω・｀)")
+	}
+	if err := r.safety.BlockURL(post.ImageURL); err != nil {
+		return err
+	}
+	if post.PhotoID != "" {
+		if err := r.safety.BlockPhotoID(post.PhotoID); err != nil {
+			return err
+		}
 	}
+	return r.poster.reply(ctx, channel, user, "もう出さないようにしたよ。ごめんね(´・ω・｀)")
 }
@@ -0,0 +1,74 @@
+package usecase
+
+import (
+	"sort"
+	"strings"
+)
+
+type flags map[string]string
+
+// 値を取るフラグ。--source mastodon のように = を省いたときは次の引数を値にする
+var valueFlags = map[string]bool{
+	"source":      true,
+	"sort":        true,
+	"tags":        true,
+	"tag-mode":    true,
+	"group":       true,
+	"user":        true,
+	"radius":      true,
+	"size":        true,
+	"orientation": true,
+	"min-width":   true,
+	"min-height":  true,
+}
+
+// --key=value / --key value / --key 形式の引数を取り出して残りの引数と分ける
+func parseFlags(args []string) ([]string, flags) {
+	rest := make([]string, 0, len(args))
+	f := flags{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") || arg == "--" {
+			rest = append(rest, arg)
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+		switch {
+		case len(kv) == 2:
+			f[kv[0]] = kv[1]
+		case valueFlags[kv[0]] && i+1 < len(args) && !strings.HasPrefix(args[i+1], "--"):
+			f[kv[0]] = args[i+1]
+			i++
+		default:
+			f[kv[0]] = ""
+		}
+	}
+	return rest, f
+}
+
+func (f flags) Has(name string) bool {
+	_, ok := f[name]
+	return ok
+}
+
+func (f flags) String(name, def string) string {
+	if v, ok := f[name]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// 値を取るフラグなのに値が渡されなかったものを返す
+func (f flags) missingValue() (string, bool) {
+	names := make([]string, 0, len(f))
+	for name, v := range f {
+		if valueFlags[name] && v == "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	return names[0], true
+}
@@ -0,0 +1,199 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mix3/iyashi-bot/domain"
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+type requestKey struct{}
+
+type request struct {
+	channel string
+	user    string
+	args    []string
+}
+
+// 投稿を記録するときにリクエストしたチャンネルと人とコマンドを残せるように context に載せておく
+func withRequest(ctx context.Context, channel, user string, args []string) context.Context {
+	return context.WithValue(ctx, requestKey{}, request{channel: channel, user: user, args: args})
+}
+
+func requestFrom(ctx context.Context) request {
+	r, _ := ctx.Value(requestKey{}).(request)
+	return r
+}
+
+type uploadKey struct{}
+
+// URL を貼る代わりに画像を取ってきてファイルとしてアップロードする
+func withUpload(ctx context.Context) context.Context {
+	return context.WithValue(ctx, uploadKey{}, true)
+}
+
+func uploadRequested(ctx context.Context) bool {
+	v, _ := ctx.Value(uploadKey{}).(bool)
+	return v
+}
+
+// 投稿した画像をリアクションで評価したり、あとから消したりできるように記録しておく
+type poster struct {
+	slackAPI repository.SlackAPI
+	postLog  repository.PostLog
+	fetcher  repository.ImageFetcher
+}
+
+func newPoster(repo repository.Repository) *poster {
+	return &poster{
+		slackAPI: repo.SlackAPI(),
+		postLog:  repo.PostLog(),
+		fetcher:  repo.ImageFetcher(),
+	}
+}
+
+func (p *poster) reply(ctx context.Context, channel, user, text string) error {
+	msg, err := p.slackAPI.Reply(ctx, channel, user, text)
+	if err != nil {
+		return err
+	}
+	return p.record(ctx, msg, nil, "")
+}
+
+func (p *poster) replyImage(ctx context.Context, channel, user string, image *domain.Image, query string) error {
+	return p.replyImages(ctx, channel, user, []*domain.Image{image}, query)
+}
+
+func (p *poster) directMessageImage(ctx context.Context, user string, image *domain.Image, query string) error {
+	return p.directMessageImages(ctx, user, []*domain.Image{image}, query)
+}
+
+// 複数枚のときは評価や削除の対象として先頭の画像を記録する
+func (p *poster) replyImages(ctx context.Context, channel, user string, images []*domain.Image, query string) error {
+	if uploadRequested(ctx) {
+		return p.upload(ctx, fmt.Sprintf("<@%s>", user), images, query, func(comment string, file repository.File) (repository.Message, error) {
+			return p.slackAPI.UploadFile(ctx, channel, comment, file)
+		})
+	}
+	msg, err := p.slackAPI.Reply(ctx, channel, user, renderImages(images))
+	if err != nil {
+		return err
+	}
+	return p.record(ctx, msg, images[0], query)
+}
+
+func (p *poster) directMessageImages(ctx context.Context, user string, images []*domain.Image, query string) error {
+	if uploadRequested(ctx) {
+		return p.upload(ctx, "", images, query, func(comment string, file repository.File) (repository.Message, error) {
+			return p.slackAPI.DirectUploadFile(ctx, user, comment, file)
+		})
+	}
+	msg, err := p.slackAPI.DirectMessage(ctx, user, renderImages(images))
+	if err != nil {
+		return err
+	}
+	return p.record(ctx, msg, images[0], query)
+}
+
+// 絵文字のように小さい画像は URL を貼っても展開されないので image block で送る
+func (p *poster) replyImageBlock(ctx context.Context, channel, user, text, imageURL, query string) error {
+	msg, err := p.slackAPI.ReplyImageBlock(ctx, channel, user, text, imageURL, text)
+	if err != nil {
+		return err
+	}
+	return p.record(ctx, msg, &domain.Image{URL: imageURL}, query)
+}
+
+func (p *poster) postImage(ctx context.Context, channel, text string, image *domain.Image, query string) error {
+	if uploadRequested(ctx) {
+		return p.upload(ctx, text, []*domain.Image{image}, query, func(comment string, file repository.File) (repository.Message, error) {
+			return p.slackAPI.UploadFile(ctx, channel, comment, file)
+		})
+	}
+	msg, err := p.slackAPI.PostMessage(ctx, channel, text+"\n"+renderImage(image))
+	if err != nil {
+		return err
+	}
+	return p.record(ctx, msg, image, query)
+}
+
+// 1 枚ずつアップロードして、先頭の投稿にだけ text とクレジットを付ける
+func (p *poster) upload(ctx context.Context, text string, images []*domain.Image, query string, send func(comment string, file repository.File) (repository.Message, error)) error {
+	for i, image := range images {
+		file, err := p.fetcher.Fetch(ctx, image.DeliveryURL())
+		if err != nil {
+			return err
+		}
+		comment := ""
+		if i == 0 {
+			lines := []string{}
+			if text != "" {
+				lines = append(lines, text)
+			}
+			if a := image.Attribution(); a != "" {
+				lines = append(lines, a)
+			}
+			comment = strings.Join(lines, "\n")
+		}
+		msg, err := send(comment, file)
+		if err != nil {
+			return err
+		}
+		if err := p.record(ctx, msg, image, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 画像 URL の下にクレジットを添える
+func renderImage(image *domain.Image) string {
+	lines := []string{image.DeliveryURL()}
+	if a := image.Attribution(); a != "" {
+		lines = append(lines, a)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 同じ投稿の画像なのでクレジットは先頭の画像のものだけ添える
+func renderImages(images []*domain.Image) string {
+	lines := []string{}
+	for _, image := range images {
+		lines = append(lines, image.DeliveryURL())
+	}
+	if a := images[0].Attribution(); a != "" {
+		lines = append(lines, a)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// image が nil ならテキストだけの投稿として記録する
+func (p *poster) record(ctx context.Context, msg repository.Message, image *domain.Image, query string) error {
+	// 共有先のメッセージがわからなかったものは消すこともできないので残さない
+	if msg.TS == "" {
+		log.Printf("[WARN] post without ts channel=%s file=%s", msg.Channel, msg.FileID)
+		return nil
+	}
+	req := requestFrom(ctx)
+	post := repository.Post{
+		Channel:        msg.Channel,
+		TS:             msg.TS,
+		RequestChannel: req.channel,
+		User:           req.user,
+		FileID:         msg.FileID,
+		Query:          query,
+		Args:           req.args,
+	}
+	if image != nil {
+		post.ImageURL = image.URL
+		post.PhotoID = image.PhotoID
+		post.Owner = image.Owner
+		if image.Query != "" {
+			post.Query = image.Query
+		}
+	}
+	return p.postLog.Record(post)
+}
@@ -0,0 +1,107 @@
+package usecase
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mix3/iyashi-bot/domain/repository"
+)
+
+// 同義語を OR でまとめた flickr 向けのキーワードにする
+func expandKeywords(dict repository.Dictionary, keywords []string) []string {
+	res := make([]string, 0, len(keywords))
+	for _, k := range keywords {
+		words := dict.Expand(k)
+		if len(words) == 1 {
+			res = append(res, words[0])
+			continue
+		}
+		res = append(res, fmt.Sprintf("(%s)", strings.Join(words, " OR ")))
+	}
+	return res
+}
+
+// tumblr のタグは完全一致なので英語のタグに寄せる
+func translateTags(dict repository.Dictionary, tags []string) []string {
+	res := make([]string, 0, len(tags))
+	for _, t := range tags {
+		res = append(res, dict.Translate(t)[0])
+	}
+	return res
+}
+
+func debugQuery(query []string) string {
+	return fmt.Sprintf("query: `%s`", strings.Join(query, " "))
+}
+
+var (
+	flickrSorts = map[string]bool{
+		"date-posted-asc":      true,
+		"date-posted-desc":     true,
+		"date-taken-asc":       true,
+		"date-taken-desc":      true,
+		"interestingness-desc": true,
+		"interestingness-asc":  true,
+		"relevance":            true,
+	}
+)
+
+// --size / --orientation / --min-width / --min-height / --sort / --tags / --tag-mode / --group / --user で preset を上書きする
+func applyFlickrFlags(query repository.FlickrQuery, f flags) (repository.FlickrQuery, error) {
+	if sort := f.String("sort", query.Sort); sort == "interesting" {
+		query.Sort = "interestingness-desc"
+	} else if sort == "" || flickrSorts[sort] {
+		query.Sort = sort
+	} else {
+		return query, fmt.Errorf("--sort は interestingness-desc / relevance / date-posted-desc などを指定してね")
+	}
+	if f.Has("tags") {
+		query.Tags = strings.Split(f.String("tags", ""), ",")
+	}
+	switch mode := f.String("tag-mode", query.TagMode); mode {
+	case "", "all", "any":
+		query.TagMode = mode
+	default:
+		return query, fmt.Errorf("--tag-mode は all / any のどちらかだよ")
+	}
+	query.GroupID = f.String("group", query.GroupID)
+	query.UserID = f.String("user", query.UserID)
+	if f.Has("radius") {
+		r, err := strconv.ParseFloat(f.String("radius", ""), 64)
+		if err != nil || r <= 0 {
+			return query, fmt.Errorf("--radius は km で指定してね")
+		}
+		query.RadiusKm = r
+	}
+
+	switch size := f.String("size", query.Size); size {
+	case "", repository.FlickrSizeMedium, repository.FlickrSizeLarge, repository.FlickrSizeHuge:
+		query.Size = size
+	default:
+		return query, fmt.Errorf("--size は z / b / h のどれかだよ")
+	}
+	switch o := f.String("orientation", query.Orientation); o {
+	case "", repository.OrientationLandscape, repository.OrientationPortrait, repository.OrientationSquare:
+		query.Orientation = o
+	default:
+		return query, fmt.Errorf("--orientation は landscape / portrait / square のどれかだよ")
+	}
+	for _, d := range []struct {
+		name string
+		v    *int
+	}{
+		{"min-width", &query.MinWidth},
+		{"min-height", &query.MinHeight},
+	} {
+		if !f.Has(d.name) {
+			continue
+		}
+		n, err := strconv.Atoi(f.String(d.name, ""))
+		if err != nil || n < 0 {
+			return query, fmt.Errorf("--%s は数字で指定してね", d.name)
+		}
+		*d.v = n
+	}
+	return query, nil
+}
@@ -2,35 +2,111 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/mix3/iyashi-bot/config"
 	"github.com/mix3/iyashi-bot/domain/repository"
 )
 
 type Usecase interface {
 	Run(ctx context.Context, channel, user string, args []string)
+	React(ctx context.Context, channel, ts, user, reaction string, added bool)
+	ScheduleDaily(ctx context.Context)
+	PostDaily(ctx context.Context) error
+	CacheStats() []repository.CacheStats
 }
 
 type usecase struct {
-	repo     repository.Repository
-	commands []Command
+	repo          repository.Repository
+	poster        *poster
+	commands      []Command
+	reactions     map[string]int
+	deleteCmd     *deleteCommand
+	deletes       map[string]bool
+	daily         *dailyCommand
+	dailyChannel  string
+	dailyPostTime time.Duration
+	dailyExternal bool
+	location      *time.Location
+	alertChannel  string
 }
 
-func NewUsecase(repo repository.Repository) Usecase {
+func NewUsecase(conf config.Config, repo repository.Repository) Usecase {
+	poster := newPoster(repo)
+	daily := newDailyCommand(repo, conf.Location())
+	deleteCmd := newDeleteCommand(repo, conf.Admins())
 	cmds := []Command{
-		newMoeCommand(repo),
-		newIyashiCommand(repo),
+		newMoeCommand(repo, conf.MoeUpload()),
+		newIyashiCommand(repo, []string{"癒やし", "癒し"}, "flicker から画像を返すよ！", repository.FlickrQuery{}, conf.MastodonDefaultHashtags()),
+		newIyashiCommand(repo, []string{"壁紙"}, "flicker から壁紙向きの大きい横長の画像を返すよ！", repository.FlickrQuery{
+			Size:        repository.FlickrSizeHuge,
+			Orientation: repository.OrientationLandscape,
+			MinWidth:    1600,
+		}, conf.MastodonDefaultHashtags()),
+		newGifCommand(repo),
+		newCommonsCommand(repo),
+		newBooruCommand(repo, conf.BooruChannelRatings()),
+		newEmojiCommand(repo),
+		daily,
+		newHallOfFameCommand(repo),
+		deleteCmd,
+		newReportCommand(repo),
 		newTumblrCommand(repo, "grass-tree-garden", []string{"しばき"}, []string{}, false),
 		newTumblrCommand(repo, "honobonoarc", []string{"萌え"}, []string{}, true),
 		newTumblrCommand(repo, "ganbaruzoi", []string{"ぞい"}, []string{}, false),
 		newTumblrCommand(repo, "tawawa-of-monday", []string{"たわわ"}, []string{"safe"}, false),
 	}
-	helpcmd := newHelpCommand(repo.SlackAPI(), cmds)
-	return &usecase{
-		repo:     repo,
-		commands: append(cmds, helpcmd),
+	for _, c := range conf.FlickrCommands() {
+		cmds = append(cmds, newIyashiCommand(repo, c.MatchStrings, c.Help, repository.FlickrQuery{
+			Keywords:    c.Keywords,
+			Tags:        c.Tags,
+			TagMode:     c.TagMode,
+			Sort:        c.Sort,
+			GroupID:     c.GroupID,
+			UserID:      c.UserID,
+			Size:        c.Size,
+			Orientation: c.Orientation,
+			MinWidth:    c.MinWidth,
+			MinHeight:   c.MinHeight,
+		}, conf.MastodonDefaultHashtags()))
 	}
+	for _, c := range conf.FeedCommands() {
+		cmds = append(cmds, newFeedCommand(repo, c.URL, c.MatchStrings, c.Help, c.Tags, c.IsDM))
+	}
+	for _, c := range conf.RedditCommands() {
+		cmds = append(cmds, newRedditCommand(repo, c.Subreddit, c.Sort, c.MatchStrings, c.Help, c.IsDM))
+	}
+	helpcmd := newHelpCommand(poster, cmds)
+	reactions := map[string]int{}
+	for _, r := range conf.LikeReactions() {
+		reactions[r] = 1
+	}
+	for _, r := range conf.DislikeReactions() {
+		reactions[r] = -1
+	}
+	deletes := map[string]bool{}
+	for _, r := range conf.DeleteReactions() {
+		deletes[r] = true
+	}
+	u := &usecase{
+		repo:          repo,
+		poster:        poster,
+		commands:      append(cmds, helpcmd),
+		reactions:     reactions,
+		deleteCmd:     deleteCmd,
+		deletes:       deletes,
+		daily:         daily,
+		dailyChannel:  conf.DailyChannel(),
+		dailyPostTime: conf.DailyPostTime(),
+		dailyExternal: conf.DailyTriggerToken() != "",
+		location:      conf.Location(),
+		alertChannel:  conf.AlertChannel(),
+	}
+	deleteCmd.rerun = u.run
+	return u
 }
 
 func (u *usecase) Run(ctx context.Context, channel, user string, args []string) {
@@ -45,15 +121,121 @@ func (u *usecase) Run(ctx context.Context, channel, user string, args []string)
 }
 
 func (u *usecase) run(ctx context.Context, channel, user string, args []string) error {
+	ctx = withRequest(ctx, channel, user, args)
+	ctx = repository.WithSafetyLevel(ctx, u.repo.Safety().Level(channel))
 	for _, c := range u.commands {
 		if c.Match(args[0]) {
 			return c.Execute(ctx, channel, user, args[1:])
 		}
 	}
-	return u.repo.SlackAPI().Reply(ctx, channel, user, "何言ってるかわかんないよ…(>﹏<;;)")
+	return u.poster.reply(ctx, channel, user, "何言ってるかわかんないよ…(>﹏<;;)")
 }
 
 func (u *usecase) err(ctx context.Context, channel, user string, err error) {
 	log.Printf("[WARN] channel=%s user=%s err:%s", channel, user, err)
-	u.repo.SlackAPI().Reply(ctx, channel, user, fmt.Sprintf("エラっちゃった(´・ω・｀) err:%s", err))
+	msg, alert := describeError(err)
+	u.poster.reply(ctx, channel, user, msg)
+	if alert {
+		u.alert(ctx, fmt.Sprintf("channel=<#%s> user=<@%s> err:%s", channel, user, err))
+	}
+}
+
+func (u *usecase) alert(ctx context.Context, text string) {
+	log.Printf("[ERROR] %s", text)
+	if u.alertChannel == "" {
+		return
+	}
+	if _, err := u.repo.SlackAPI().PostMessage(ctx, u.alertChannel, ":rotating_light: "+text); err != nil {
+		log.Printf("[ERROR] alert channel=%s err:%s", u.alertChannel, err)
+	}
+}
+
+// 利用者向けの返事と、運用側に知らせるべきかどうかを決める
+func describeError(err error) (string, bool) {
+	switch {
+	case errors.Is(err, repository.ErrorInvalidAPIKey):
+		return "API キーが無効みたい…管理者に伝えておくね(´・ω・｀)", true
+	case errors.Is(err, repository.ErrorRateLimited):
+		return "いっぱい呼ばれすぎて休憩中だよ。ちょっと待ってからまた呼んでね(´・ω・｀)", true
+	case errors.Is(err, repository.ErrorBlogNotFound):
+		return "ブログが見つかんなかったよ。消えちゃったのかも(´・ω・｀)", true
+	case errors.Is(err, repository.ErrorImageTooLarge):
+		return "画像が大きすぎてアップロードできなかったよ(´・ω・｀)", false
+	case errors.Is(err, repository.ErrorUnsupportedImage):
+		return "画像じゃないものが返ってきたよ(´・ω・｀)", false
+	case errors.Is(err, repository.ErrorUpstreamUnavailable):
+		return "画像の取得先が調子悪いみたい。また後で呼んでね(´・ω・｀)", false
+	}
+	return fmt.Sprintf("エラっちゃった(´・ω・｀) err:%s", err), false
+}
+
+// bot が投稿した画像へのリアクションを評価として記録したり、削除の合図として扱う
+func (u *usecase) React(ctx context.Context, channel, ts, user, reaction string, added bool) {
+	if user == u.repo.SlackAPI().UserID() {
+		return
+	}
+	post, ok := u.repo.PostLog().Find(channel, ts)
+	if !ok || post.ImageURL == "" {
+		return
+	}
+	if u.deletes[reaction] {
+		if !added || !u.deleteCmd.allowed(post, user) {
+			return
+		}
+		if err := u.deleteCmd.delete(ctx, post); err != nil {
+			log.Printf("[WARN] channel=%s ts=%s reaction=%s err:%s", channel, ts, reaction, err)
+		}
+		return
+	}
+	delta, ok := u.reactions[reaction]
+	if !ok {
+		return
+	}
+	if !added {
+		delta = -delta
+	}
+	if err := u.repo.ScoreStore().AddScore(post.ImageURL, post.Query, delta); err != nil {
+		log.Printf("[WARN] channel=%s ts=%s reaction=%s err:%s", channel, ts, reaction, err)
+	}
+}
+
+// 毎日 dailyPostTime に dailyChannel へ今日の癒しを投稿する。
+// 外部のスケジュールから PostDaily を呼ぶ設定のときは何もしない
+func (u *usecase) ScheduleDaily(ctx context.Context) {
+	if u.dailyChannel == "" || u.dailyExternal {
+		return
+	}
+	for {
+		now := time.Now().In(u.location)
+		next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, u.location).Add(u.dailyPostTime)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		log.Printf("[INFO] next daily post at %s", next)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+		u.PostDaily(ctx)
+	}
+}
+
+// dailyChannel へ今日の癒しを投稿する。同じ日に何度呼ばれても投稿は 1 回だけ
+func (u *usecase) PostDaily(ctx context.Context) error {
+	if u.dailyChannel == "" {
+		return nil
+	}
+	if err := u.daily.Post(ctx, u.dailyChannel); err != nil {
+		log.Printf("[WARN] daily post channel=%s err:%s", u.dailyChannel, err)
+		if _, alert := describeError(err); alert {
+			u.alert(ctx, fmt.Sprintf("daily post channel=<#%s> err:%s", u.dailyChannel, err))
+		}
+		return err
+	}
+	return nil
+}
+
+func (u *usecase) CacheStats() []repository.CacheStats {
+	return u.repo.CacheStats()
 }